@@ -1,50 +1,57 @@
 package alexsidebar_api
 
 import (
-	"alexsidebar2api/common"
 	"alexsidebar2api/common/config"
 	logger "alexsidebar2api/common/loggger"
 	"alexsidebar2api/cycletls"
+	"context"
 	"fmt"
-	"github.com/gin-gonic/gin"
+	"time"
 )
 
-const (
-	baseURL      = "https://api.alexcodes.app"
-	chatEndpoint = baseURL + "/call_assistant5"
-)
+const chatPath = "/call_assistant5"
 
-func MakeStreamChatRequest(c *gin.Context, client cycletls.CycleTLS, jsonData []byte, cookie string) (<-chan cycletls.SSEResponse, error) {
+// MakeStreamChatRequest 发起上游对话请求, ctx 结束时上游连接随之关闭
+func MakeStreamChatRequest(ctx context.Context, client Client, jsonData []byte, cookie, proxy string) (<-chan cycletls.SSEResponse, error) {
 	//split := strings.Split(cookie, "=")
 	tokenInfo, ok := config.ASTokenMap[cookie]
 	if !ok {
 		return nil, fmt.Errorf("cookie not found in ASTokenMap")
 	}
 
+	fingerprint := config.AccountFingerprint(cookie)
 	options := cycletls.Options{
-		Timeout: 10 * 60 * 60,
-		Proxy:   config.ProxyUrl, // 在每个请求中设置代理
-		Body:    string(jsonData),
-		Method:  "POST",
+		Ja3:            fingerprint.JA3,
+		UserAgent:      fingerprint.UserAgent,
+		ClientType:     fingerprint.ClientType,
+		Timeout:        int(config.RequestOutTimeDuration.Seconds()),
+		ConnectTimeout: config.UpstreamConnectTimeout,
+		Proxy:          proxy, // 在每个请求中设置代理
+		Body:           string(jsonData),
+		Method:         "POST",
 		Headers: map[string]string{
-			"User-Agent":       config.UserAgent,
+			"User-Agent":       fingerprint.UserAgent,
 			"Content-Type":     "application/json",
-			"app-version":      "2.5.6",
-			"app-build-number": "190",
+			"app-version":      fingerprint.AppVersion,
+			"app-build-number": fingerprint.BuildNumber,
 			"auth":             tokenInfo.AccessToken,
-			"accept-language":  "zh-CN,zh-Hans;q=0.9",
-			"local-id":         common.GenerateSerialNumber(10),
+			"accept-language":  fingerprint.Locale,
+			"local-id":         config.AccountDeviceID(cookie),
 		},
 	}
 
-	logger.Debug(c.Request.Context(), fmt.Sprintf("cookie: %v", cookie))
+	logger.Debug(ctx, fmt.Sprintf("cookie: %v", cookie))
 
-	logger.Debug(c, fmt.Sprintf("%s", options))
+	logger.Debug(ctx, fmt.Sprintf("%v", options))
 
-	sseChan, err := client.DoSSE(chatEndpoint, options, "POST")
+	upstreamCtx, cancel := context.WithCancel(ctx)
+	sseChan, err := client.DoSSE(upstreamCtx, config.ASBaseURL+chatPath, options, "POST")
 	if err != nil {
-		logger.Errorf(c, "Failed to make stream request: %v", err)
+		cancel()
+		logger.Errorf(ctx, "Failed to make stream request: %v", err)
 		return nil, fmt.Errorf("failed to make stream request: %v", err)
 	}
-	return sseChan, nil
+	return withFrameTimeouts(ctx, cancel, sseChan,
+		time.Duration(config.UpstreamFirstFrameTimeout)*time.Second,
+		time.Duration(config.UpstreamIdleTimeout)*time.Second), nil
 }
@@ -0,0 +1,184 @@
+package alexsidebar_api
+
+import (
+	"alexsidebar2api/common"
+	"alexsidebar2api/common/config"
+	logger "alexsidebar2api/common/loggger"
+	"alexsidebar2api/cycletls"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// CapturedStream 一次上游请求及其原始响应帧, 对应录制文件中的一行
+type CapturedStream struct {
+	Time      int64                  `json:"time"`
+	Key       string                 `json:"key"`
+	Request   json.RawMessage        `json:"request"`
+	Responses []cycletls.SSEResponse `json:"responses"`
+}
+
+var captureMutex sync.Mutex
+
+// replayKey 忽略消息ID、创建时间等易变字段, 使同一对话多次请求得到相同的 key
+func replayKey(jsonData []byte) string {
+	var body struct {
+		Model    string `json:"model"`
+		Prompt   string `json:"prompt"`
+		Messages []struct {
+			Role       string          `json:"role"`
+			ThinkFirst bool            `json:"think_first"`
+			Sections   json.RawMessage `json:"sections"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(jsonData, &body); err != nil {
+		return common.StringToSHA256(string(jsonData))
+	}
+	normalized, _ := json.Marshal(body)
+	return common.StringToSHA256(string(normalized))
+}
+
+// CaptureClient 透传上游帧, 流结束后将请求与全部帧追加写入录制文件
+type CaptureClient struct {
+	Client Client
+	Path   string
+}
+
+func (cc *CaptureClient) DoSSE(ctx context.Context, URL string, options cycletls.Options, Method string) (<-chan cycletls.SSEResponse, error) {
+	in, err := cc.Client.DoSSE(ctx, URL, options, Method)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan cycletls.SSEResponse)
+	go func() {
+		defer close(out)
+		record := CapturedStream{
+			Time:    time.Now().Unix(),
+			Key:     replayKey([]byte(options.Body)),
+			Request: json.RawMessage(options.Body),
+		}
+		for response := range in {
+			record.Responses = append(record.Responses, response)
+			select {
+			case out <- response:
+			case <-ctx.Done():
+				// 不再转发, 继续读取直至上游通道关闭以保留完整录制
+			}
+		}
+		if err := appendCapture(cc.Path, record); err != nil {
+			logger.SysError(fmt.Sprintf("appendCapture err: %v", err))
+		}
+	}()
+	return out, nil
+}
+
+func appendCapture(path string, record CapturedStream) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	captureMutex.Lock()
+	defer captureMutex.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// LoadCaptures 读取录制文件
+func LoadCaptures(path string) ([]CapturedStream, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []CapturedStream
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record CapturedStream
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("parse capture line %d err: %v", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// ErrReplayMiss 录制文件中没有与请求匹配的上游流
+var ErrReplayMiss = errors.New("no captured stream matches the request")
+
+// Replayer 按请求内容回放录制的上游流, 未命中时返回 ErrReplayMiss
+type Replayer struct {
+	records []CapturedStream
+	byKey   map[string][]int
+	mu      sync.Mutex
+}
+
+func NewReplayer(records []CapturedStream) *Replayer {
+	r := &Replayer{records: records, byKey: map[string][]int{}}
+	for i, record := range records {
+		r.byKey[record.Key] = append(r.byKey[record.Key], i)
+	}
+	return r
+}
+
+func (r *Replayer) pick(jsonData []byte) (CapturedStream, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := replayKey(jsonData)
+	// 同一 key 的多条录制依次使用, 以便回放重试场景
+	if indexes := r.byKey[key]; len(indexes) > 0 {
+		r.byKey[key] = append(indexes[1:], indexes[0])
+		return r.records[indexes[0]], true
+	}
+	return CapturedStream{}, false
+}
+
+// DoSSE 按请求体回放录制的帧
+func (r *Replayer) DoSSE(ctx context.Context, URL string, options cycletls.Options, Method string) (<-chan cycletls.SSEResponse, error) {
+	record, ok := r.pick([]byte(options.Body))
+	if !ok {
+		return nil, fmt.Errorf("%w (key %s)", ErrReplayMiss, replayKey([]byte(options.Body)))
+	}
+	out := make(chan cycletls.SSEResponse, len(record.Responses))
+	for _, response := range record.Responses {
+		out <- response
+	}
+	close(out)
+	return out, nil
+}
+
+var (
+	replayer     *Replayer
+	replayerOnce sync.Once
+	replayerErr  error
+)
+
+func getReplayer() (*Replayer, error) {
+	replayerOnce.Do(func() {
+		records, err := LoadCaptures(config.UpstreamReplayFile)
+		if err != nil {
+			replayerErr = fmt.Errorf("load replay file err: %v", err)
+			return
+		}
+		replayer = NewReplayer(records)
+		logger.SysLog(fmt.Sprintf("loaded %d captured streams from %s", len(records), config.UpstreamReplayFile))
+	})
+	return replayer, replayerErr
+}
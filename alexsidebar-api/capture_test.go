@@ -0,0 +1,38 @@
+package alexsidebar_api
+
+import (
+	"alexsidebar2api/cycletls"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestReplayerMiss(t *testing.T) {
+	body := `{"model":"agent_sonnet_37","prompt":"","messages":[]}`
+	replayer := NewReplayer([]CapturedStream{{
+		Key:       replayKey([]byte(`{"model":"agent_sonnet_37","prompt":"other","messages":[]}`)),
+		Responses: []cycletls.SSEResponse{{Status: http.StatusOK, Data: "[DONE]", Done: true}},
+	}})
+	if _, err := replayer.DoSSE(context.Background(), "", cycletls.Options{Body: body}, http.MethodPost); !errors.Is(err, ErrReplayMiss) {
+		t.Fatalf("expected ErrReplayMiss, got %v", err)
+	}
+}
+
+func TestReplayerSameKeyInOrder(t *testing.T) {
+	body := `{"model":"agent_sonnet_37","prompt":"","messages":[]}`
+	key := replayKey([]byte(body))
+	replayer := NewReplayer([]CapturedStream{
+		{Key: key, Responses: []cycletls.SSEResponse{{Data: "first"}}},
+		{Key: key, Responses: []cycletls.SSEResponse{{Data: "second"}}},
+	})
+	for _, want := range []string{"first", "second", "first"} {
+		ch, err := replayer.DoSSE(context.Background(), "", cycletls.Options{Body: body}, http.MethodPost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (<-ch).Data; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
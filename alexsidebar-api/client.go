@@ -0,0 +1,214 @@
+package alexsidebar_api
+
+import (
+	"alexsidebar2api/common/config"
+	logger "alexsidebar2api/common/loggger"
+	"alexsidebar2api/cycletls"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client 上游客户端, cycletls.CycleTLS 天然满足该接口
+// ctx 结束时实现必须断开上游连接并关闭返回的通道, 不得遗留协程
+type Client interface {
+	DoSSE(ctx context.Context, URL string, options cycletls.Options, Method string) (<-chan cycletls.SSEResponse, error)
+}
+
+const (
+	TransportCycleTLS = "cycletls"
+	TransportHTTP     = "http"
+	TransportFake     = "fake"
+	TransportReplay   = "replay"
+)
+
+var (
+	defaultClient     Client
+	defaultClientErr  error
+	defaultClientOnce sync.Once
+)
+
+// DefaultClient 进程内共享的上游客户端, 请求间复用连接池
+func DefaultClient() (Client, error) {
+	defaultClientOnce.Do(func() {
+		defaultClient, defaultClientErr = NewClient()
+	})
+	return defaultClient, defaultClientErr
+}
+
+// SetDefaultClient 替换进程内共享的上游客户端, 用于测试时注入 FakeClient 或 Replayer
+func SetDefaultClient(client Client) {
+	defaultClientOnce.Do(func() {})
+	defaultClient, defaultClientErr = client, nil
+}
+
+// NewClient 按配置创建上游客户端, 配置了回放文件时总是使用回放
+func NewClient() (Client, error) {
+	var client Client
+	transport := strings.ToLower(config.UpstreamTransport)
+	if config.UpstreamReplayFile != "" {
+		transport = TransportReplay
+	}
+
+	switch transport {
+	case "", TransportCycleTLS:
+		client = cycletls.Init()
+	case TransportHTTP:
+		client = &HTTPClient{}
+	case TransportFake:
+		client = &FakeClient{Frames: DefaultFakeFrames}
+	case TransportReplay:
+		r, err := getReplayer()
+		if err != nil {
+			return nil, err
+		}
+		client = r
+	default:
+		return nil, fmt.Errorf("unknown upstream transport: %s", config.UpstreamTransport)
+	}
+
+	if config.UpstreamCaptureFile != "" && transport != TransportReplay {
+		client = &CaptureClient{Client: client, Path: config.UpstreamCaptureFile}
+	}
+	return client, nil
+}
+
+// HTTPClient 基于 net/http 的上游客户端, 不做 TLS 指纹伪装
+type HTTPClient struct {
+	clients sync.Map // proxy -> *http.Client
+}
+
+func (h *HTTPClient) getClient(proxy string) (*http.Client, error) {
+	if client, ok := h.clients.Load(proxy); ok {
+		return client.(*http.Client), nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	client, _ := h.clients.LoadOrStore(proxy, &http.Client{Transport: transport})
+	return client.(*http.Client), nil
+}
+
+func (h *HTTPClient) DoSSE(ctx context.Context, URL string, options cycletls.Options, Method string) (<-chan cycletls.SSEResponse, error) {
+	client, err := h.getClient(options.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, Method, URL, strings.NewReader(options.Body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range options.Headers {
+		req.Header.Set(k, v)
+	}
+	if options.UserAgent != "" {
+		req.Header.Set("User-Agent", options.UserAgent)
+	}
+
+	sseChan := make(chan cycletls.SSEResponse)
+	go func() {
+		defer close(sseChan)
+
+		// 与 cycletls 一致, Timeout 限制整个请求(含读取响应体)的时长
+		if options.Timeout > 0 {
+			timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(options.Timeout)*time.Second)
+			defer cancel()
+			req = req.WithContext(timeoutCtx)
+		}
+
+		var connectTimer *time.Timer
+		var connectTimedOut atomic.Bool
+		if options.ConnectTimeout > 0 {
+			connectCtx, cancel := context.WithCancel(req.Context())
+			defer cancel()
+			req = req.WithContext(connectCtx)
+			connectTimer = time.AfterFunc(time.Duration(options.ConnectTimeout)*time.Second, func() {
+				connectTimedOut.Store(true)
+				cancel()
+			})
+		}
+
+		resp, err := client.Do(req)
+		// 已收到响应头, 连接超时不再生效
+		if connectTimer != nil && !connectTimer.Stop() && connectTimedOut.Load() {
+			if err == nil {
+				resp.Body.Close()
+			}
+			err = errors.New(cycletls.ConnectTimeoutError)
+		}
+		if err != nil {
+			logger.Errorf(ctx, "http upstream request err: %v", err)
+			status, data := http.StatusBadGateway, err.Error()
+			if connectTimedOut.Load() {
+				status, data = http.StatusRequestTimeout, cycletls.ConnectTimeoutError
+			}
+			select {
+			case sseChan <- cycletls.SSEResponse{
+				Status:   status,
+				Data:     data,
+				Done:     true,
+				FinalUrl: URL,
+			}:
+			case <-ctx.Done():
+			}
+			return
+		}
+		defer resp.Body.Close()
+
+		cycletls.ReadSSE(ctx, resp.Body, resp.StatusCode, "", resp.Request.URL.String(), sseChan)
+	}()
+	return sseChan, nil
+}
+
+// DefaultFakeFrames 配置 UPSTREAM_TRANSPORT=fake 时返回的固定响应
+var DefaultFakeFrames = []string{
+	`{"sections":[{"text":{"text":"Fake","is_thinking":false}}]}`,
+	`{"sections":[{"text":{"text":"Fake upstream reply.","is_thinking":false}}]}`,
+}
+
+// FakeClient 内存上游, 按顺序返回预设的帧, Status 非2xx时返回 Body 作为错误
+type FakeClient struct {
+	Status   int
+	Body     string
+	Frames   []string
+	Record   bool               // 是否记录收到的请求
+	Requests []cycletls.Options // 收到的请求, 便于断言
+	mu       sync.Mutex
+}
+
+func (f *FakeClient) DoSSE(ctx context.Context, URL string, options cycletls.Options, Method string) (<-chan cycletls.SSEResponse, error) {
+	if f.Record {
+		f.mu.Lock()
+		options.URL = URL
+		options.Method = Method
+		f.Requests = append(f.Requests, options)
+		f.mu.Unlock()
+	}
+
+	status := f.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := f.Body
+	if status >= 200 && status < 300 {
+		body = strings.Join(f.Frames, "›")
+	}
+
+	sseChan := make(chan cycletls.SSEResponse)
+	go func() {
+		defer close(sseChan)
+		cycletls.ReadSSE(ctx, strings.NewReader(body), status, "", URL, sseChan)
+	}()
+	return sseChan, nil
+}
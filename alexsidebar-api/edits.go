@@ -0,0 +1,172 @@
+package alexsidebar_api
+
+import (
+	"regexp"
+	"strings"
+)
+
+// FileEdit 上游输出中的文件修改
+// OldContent 为空时 Content 为文件的完整内容, 否则为替换 OldContent 的片段
+type FileEdit struct {
+	Path       string `json:"path"`
+	Content    string `json:"content"`
+	OldContent string `json:"old_content,omitempty"`
+	Language   string `json:"language,omitempty"`
+}
+
+// 上游文本中的修改标记:
+//
+//	<new_file path="a.go">完整内容</new_file>
+//	<old_code path="a.go">原片段</old_code><new_code path="a.go">新片段</new_code>
+var (
+	editBlockPattern = regexp.MustCompile(`(?s)(?:<old_code(\s[^>]*)?>(.*?)</old_code>\s*)?<(new_code|new_file)(\s[^>]*)?>(.*?)</(?:new_code|new_file)>\n?`)
+	editPathPattern  = regexp.MustCompile(`(?:file_)?path\s*=\s*"([^"]*)"`)
+	editOpenTags     = []string{"<old_code", "<new_code", "<new_file"}
+)
+
+// 暂缓输出的上限: 开始标签超过 maxEditTagLen 仍不完整, 或标记开始后超过 maxHeldEditBytes 仍未闭合时视为正文输出
+const (
+	maxEditTagLen    = 512
+	maxHeldEditBytes = 64 * 1024
+)
+
+var editOpenTagPattern = regexp.MustCompile(`^<(?:old_code|new_code|new_file)(?:\s[^>\n]*)?>`)
+
+// ExtractEdits 提取文本中完整的修改标记, 返回去掉标记后的文本
+// final 为 false 时文本仍在增长, 未闭合的标记及其之后的内容暂不返回
+func ExtractEdits(text string, final bool) (string, []FileEdit) {
+	var scanner EditScanner
+	return scanner.Scan(text, final)
+}
+
+// EditScanner 逐帧提取同一段文本中的修改标记, 只扫描上次之后新增的部分
+type EditScanner struct {
+	text     string          // 已扫描的原文
+	consumed int             // 原文中已处理的位置, 之前的修改标记已提取
+	visible  strings.Builder // consumed 之前去掉标记后的文本
+	edits    []FileEdit
+}
+
+// Scan 返回截至当前去掉标记后的文本及全部修改, text 不是上次原文的延续时重新扫描
+func (s *EditScanner) Scan(text string, final bool) (string, []FileEdit) {
+	if !strings.HasPrefix(text, s.text[:s.consumed]) {
+		*s = EditScanner{}
+	}
+	s.text = text
+
+	for {
+		rest := text[s.consumed:]
+		var loc []int
+		if strings.Contains(rest, "</new_") {
+			loc = editBlockPattern.FindStringSubmatchIndex(rest)
+		}
+		pending := s.pendingStart(rest, final)
+		if pending >= 0 && (loc == nil || pending < loc[0]) {
+			// 未闭合的标记之前没有完整的标记
+			s.visible.WriteString(rest[:pending])
+			s.consumed += pending
+			return s.visible.String(), s.edits
+		}
+		if loc == nil {
+			if final {
+				return s.visible.String() + rest, s.edits
+			}
+			// 已确认不含标记的部分下次不再扫描
+			s.visible.WriteString(rest)
+			s.consumed += len(rest)
+			return s.visible.String(), s.edits
+		}
+
+		s.visible.WriteString(rest[:loc[0]])
+		if !final && loc[1] == len(rest) && !strings.HasSuffix(rest, "\n") {
+			// 结束标签后的换行随标记一起去掉, 等待下一帧确认
+			s.consumed += loc[0]
+			return s.visible.String(), s.edits
+		}
+		s.edits = append(s.edits, newFileEdit(rest, loc))
+		s.consumed += loc[1]
+	}
+}
+
+// pendingStart 返回未闭合标记(含末尾不完整的标签名)的起始位置, 不存在或 final 时返回 -1
+// 超过暂缓上限的开始标签视为正文
+func (s *EditScanner) pendingStart(rest string, final bool) int {
+	if final {
+		return -1
+	}
+	for offset := 0; offset < len(rest); {
+		i := pendingEditMarkup(rest[offset:])
+		if i < 0 {
+			return -1
+		}
+		start := offset + i
+		tail := rest[start:]
+		switch {
+		case editOpenTagPattern.MatchString(tail):
+			if len(tail) <= maxHeldEditBytes {
+				return start
+			}
+		case len(tail) <= maxEditTagLen && !strings.Contains(tail, "\n"):
+			// 开始标签尚未完整
+			return start
+		}
+		offset = start + 1
+	}
+	return -1
+}
+
+func newFileEdit(text string, loc []int) FileEdit {
+	group := func(n int) string {
+		if loc[2*n] < 0 {
+			return ""
+		}
+		return text[loc[2*n]:loc[2*n+1]]
+	}
+	edit := FileEdit{
+		Path:    editPath(group(4)),
+		Content: trimEditContent(group(5)),
+	}
+	if group(3) == "new_code" {
+		edit.OldContent = trimEditContent(group(2))
+	}
+	if edit.Path == "" {
+		edit.Path = editPath(group(1))
+	}
+	return edit
+}
+
+// pendingEditMarkup 返回第一个开始标签(含末尾不完整的标签名)的起始位置, 不存在时返回 -1
+func pendingEditMarkup(text string) int {
+	pending := -1
+	for _, tag := range editOpenTags {
+		if i := strings.Index(text, tag); i >= 0 && (pending < 0 || i < pending) {
+			pending = i
+		}
+	}
+	if pending >= 0 {
+		return pending
+	}
+
+	if i := strings.LastIndex(text, "<"); i >= 0 {
+		tail := text[i:]
+		for _, tag := range editOpenTags {
+			if strings.HasPrefix(tag, tail) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func editPath(attrs string) string {
+	if m := editPathPattern.FindStringSubmatch(attrs); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// trimEditContent 去掉标签内侧紧邻的换行
+func trimEditContent(content string) string {
+	content = strings.TrimPrefix(content, "\n")
+	return strings.TrimSuffix(content, "\n")
+}
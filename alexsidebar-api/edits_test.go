@@ -0,0 +1,130 @@
+package alexsidebar_api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractEdits(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		final   bool
+		visible string
+		edits   []FileEdit
+	}{
+		{
+			name:    "new file",
+			text:    "Done:\n<new_file path=\"a.go\">\npackage a\n</new_file>\nbye",
+			visible: "Done:\nbye",
+			edits:   []FileEdit{{Path: "a.go", Content: "package a"}},
+		},
+		{
+			name:    "replace snippet",
+			text:    "<old_code path=\"a.go\">x := 1</old_code>\n<new_code path=\"a.go\">x := 2</new_code>",
+			final:   true,
+			visible: "",
+			edits:   []FileEdit{{Path: "a.go", Content: "x := 2", OldContent: "x := 1"}},
+		},
+		{
+			// 结束标签后可能紧跟换行, 等待下一帧
+			name:    "block at end of frame",
+			text:    "ok\n<new_file path=\"a.go\">a</new_file>",
+			visible: "ok\n",
+		},
+		{
+			name:    "unclosed block held",
+			text:    "Here:\n<new_file path=\"a.go\">\npackage",
+			visible: "Here:\n",
+		},
+		{
+			name:    "unclosed block released when final",
+			text:    "Here:\n<new_file path=\"a.go\">\npackage",
+			final:   true,
+			visible: "Here:\n<new_file path=\"a.go\">\npackage",
+		},
+		{
+			name:    "partial tag name held",
+			text:    "Here: <new_fi",
+			visible: "Here: ",
+		},
+		{
+			name:    "literal tag in prose",
+			text:    "Use the <new_file tag for new files.\nThen continue.",
+			visible: "Use the <new_file tag for new files.\nThen continue.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			visible, edits := ExtractEdits(tt.text, tt.final)
+			if visible != tt.visible {
+				t.Errorf("visible %q, want %q", visible, tt.visible)
+			}
+			if !reflect.DeepEqual(edits, tt.edits) {
+				t.Errorf("edits %+v, want %+v", edits, tt.edits)
+			}
+		})
+	}
+}
+
+// TestEditScannerIncremental 逐字增长扫描与一次性扫描结果一致, 且已返回的文本只增不减
+func TestEditScannerIncremental(t *testing.T) {
+	text := "Intro <b>bold</b>\n<new_file path=\"a.go\">\npackage a\n</new_file>\nmiddle\n" +
+		"<old_code path=\"b.go\">x := 1</old_code>\n<new_code path=\"b.go\">x := 2</new_code>\nend"
+	var scanner EditScanner
+	prev := ""
+	for i := 1; i <= len(text); i++ {
+		visible, _ := scanner.Scan(text[:i], false)
+		if !strings.HasPrefix(visible, prev) {
+			t.Fatalf("at %d: visible %q does not extend %q", i, visible, prev)
+		}
+		prev = visible
+	}
+	visible, edits := scanner.Scan(text, true)
+	wantVisible, wantEdits := ExtractEdits(text, true)
+	if visible != wantVisible || !reflect.DeepEqual(edits, wantEdits) {
+		t.Errorf("incremental %q %+v, full %q %+v", visible, edits, wantVisible, wantEdits)
+	}
+	if len(edits) != 2 {
+		t.Errorf("expected 2 edits, got %+v", edits)
+	}
+}
+
+// TestEditScannerHoldCap 未闭合的标记超过上限后不再暂缓输出
+func TestEditScannerHoldCap(t *testing.T) {
+	var scanner EditScanner
+	text := "Wrap files in <new_file> tags. " + strings.Repeat("word ", maxHeldEditBytes/5+1)
+	visible, _ := scanner.Scan(text, false)
+	if visible != text {
+		t.Errorf("held %d bytes past the cap", len(text)-len(visible))
+	}
+
+	// 标签被当作正文输出后, 后续内容照常输出
+	visible, _ = scanner.Scan(text+"more", false)
+	if visible != text+"more" {
+		t.Errorf("output after released tag was held")
+	}
+}
+
+func TestEditScannerRewrite(t *testing.T) {
+	var scanner EditScanner
+	scanner.Scan("<new_file path=\"a.go\">a</new_file>old", false)
+	visible, edits := scanner.Scan("<new_file path=\"b.go\">b</new_file>new", false)
+	if visible != "new" || len(edits) != 1 || edits[0].Path != "b.go" {
+		t.Errorf("rescan after rewrite: %q %+v", visible, edits)
+	}
+}
+
+func BenchmarkEditScannerStream(b *testing.B) {
+	text := strings.Repeat("Some prose with <b>markup</b> and code.\n", 2000) +
+		"<new_file path=\"a.go\">\n" + strings.Repeat("x := 1\n", 1000) + "</new_file>\n"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var scanner EditScanner
+		for end := 64; end < len(text); end += 64 {
+			scanner.Scan(text[:end], false)
+		}
+		scanner.Scan(text, true)
+	}
+}
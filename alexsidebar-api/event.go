@@ -0,0 +1,121 @@
+package alexsidebar_api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Event 上游单帧, 每帧携带截至当前的全部 sections
+type Event struct {
+	Sections []Section `json:"sections"`
+	Done     bool      `json:"-"` // [DONE] 结束帧
+}
+
+// Section 上游内容段, text/code 之外的段类型保留在 Others 中
+type Section struct {
+	Text   *TextSection
+	Code   *CodeSection
+	Others map[string]json.RawMessage
+}
+
+// TextSection 文本段, is_thinking 为思考过程
+type TextSection struct {
+	Text       string `json:"text"`
+	IsThinking bool   `json:"is_thinking"`
+}
+
+// CodeSection 代码段
+type CodeSection struct {
+	Code     string `json:"code"`
+	Language string `json:"language"`
+	FilePath string `json:"file_path"`
+}
+
+// Kind 段类型, 无内容时返回空字符串
+func (s Section) Kind() string {
+	switch {
+	case s.Text != nil:
+		return "text"
+	case s.Code != nil:
+		return "code"
+	}
+	for kind := range s.Others {
+		return kind
+	}
+	return ""
+}
+
+func (s *Section) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s = Section{}
+	for kind, value := range raw {
+		if string(value) == "null" {
+			continue
+		}
+		switch kind {
+		case "text":
+			s.Text = &TextSection{}
+			if err := json.Unmarshal(value, s.Text); err != nil {
+				return fmt.Errorf("text section: %v", err)
+			}
+		case "code":
+			s.Code = &CodeSection{}
+			if err := json.Unmarshal(value, s.Code); err != nil {
+				return fmt.Errorf("code section: %v", err)
+			}
+		default:
+			if s.Others == nil {
+				s.Others = map[string]json.RawMessage{}
+			}
+			s.Others[kind] = value
+		}
+	}
+	return nil
+}
+
+func (s Section) MarshalJSON() ([]byte, error) {
+	raw := map[string]interface{}{}
+	for kind, value := range s.Others {
+		raw[kind] = value
+	}
+	if s.Text != nil {
+		raw["text"] = s.Text
+	}
+	if s.Code != nil {
+		raw["code"] = s.Code
+	}
+	return json.Marshal(raw)
+}
+
+var errEmptyEvent = errors.New("empty upstream event")
+
+// DecodeEvent 解析上游单帧, 格式不合法时返回错误而不是 panic
+func DecodeEvent(data string) (Event, error) {
+	data = strings.TrimSpace(data)
+	data = strings.TrimPrefix(data, "data: ")
+	if data == "" {
+		return Event{}, errEmptyEvent
+	}
+	if data == "[DONE]" {
+		return Event{Done: true}, nil
+	}
+
+	var event Event
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return Event{}, err
+	}
+	return event, nil
+}
+
+// LastSection 最后一个内容段
+func (e Event) LastSection() (Section, bool) {
+	if len(e.Sections) == 0 {
+		return Section{}, false
+	}
+	return e.Sections[len(e.Sections)-1], true
+}
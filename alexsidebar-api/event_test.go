@@ -0,0 +1,55 @@
+package alexsidebar_api
+
+import (
+	"alexsidebar2api/cycletls"
+	"bufio"
+	"strings"
+	"testing"
+)
+
+// FuzzDecodeEvent 任意上游响应体按 › 分帧解析并计算增量, 格式不合法时只能返回错误, 不能 panic
+func FuzzDecodeEvent(f *testing.F) {
+	seeds := []string{
+		`{"sections":[{"text":{"text":"Hello","is_thinking":false}}]}›[DONE]`,
+		`{"sections":[{"code":{"code":"fmt.Println()","language":"go","file_path":"main.go"}}]}›`,
+		// 截断的 JSON
+		`{"sections":[{"text":{"text":"Hel`,
+		`{"sections":[{"text":`,
+		`{"sections":[{"code":{"code":1}}]}`,
+		// 缺少 › 分隔符, 两帧粘连
+		`{"sections":[{"text":{"text":"a"}}]}{"sections":[{"text":{"text":"ab"}}]}`,
+		`{"sections":[{"text":{"text":"a"}}]}` + "\xe2\x80",
+		// 未知的段类型
+		`{"sections":[{"tool_use":{"name":"x"}},{"text":{"text":"<new_file path=\"a\">x"}}]}›{"sections":[{"tool_use":null},{"image":"?"}]}`,
+		`{"sections":[null,{},{"text":null}]}›data: [DONE]`,
+		`{"sections":{}}›[]›null›"str"››`,
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, body string) {
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		scanner.Split(cycletls.ScanSections)
+
+		var tracker SectionTracker
+		for scanner.Scan() {
+			event, err := DecodeEvent(scanner.Text())
+			if err != nil {
+				continue
+			}
+			if section, ok := event.LastSection(); ok {
+				section.Kind()
+				if _, err := section.MarshalJSON(); err != nil {
+					t.Errorf("marshal decoded section: %v", err)
+				}
+			}
+			for _, delta := range tracker.Update(event) {
+				if delta.Section.Text != nil {
+					ExtractEdits(delta.Section.Text.Text, event.Done)
+				}
+			}
+		}
+	})
+}
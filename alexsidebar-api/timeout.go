@@ -0,0 +1,62 @@
+package alexsidebar_api
+
+import (
+	"alexsidebar2api/common"
+	"alexsidebar2api/cycletls"
+	"context"
+	"net/http"
+	"time"
+)
+
+// withFrameTimeouts 监控首帧与相邻帧间隔, 超时后断开上游并返回超时错误帧
+// ctx 为调用方上下文, cancel 取消上游请求, 输出通道关闭时总会调用 cancel
+func withFrameTimeouts(ctx context.Context, cancel context.CancelFunc, in <-chan cycletls.SSEResponse, firstFrame, idle time.Duration) <-chan cycletls.SSEResponse {
+	out := make(chan cycletls.SSEResponse)
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		stage, timeout := "first frame", firstFrame
+		timer := time.NewTimer(timeout)
+		if timeout <= 0 {
+			timer.Stop()
+		}
+		defer timer.Stop()
+
+		for {
+			select {
+			case response, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- response:
+				case <-ctx.Done():
+					return
+				}
+				stage, timeout = "idle", idle
+				timer.Stop()
+				if timeout > 0 {
+					timer.Reset(timeout)
+				}
+			case <-timer.C:
+				cancel()
+				select {
+				case out <- cycletls.SSEResponse{
+					Status: http.StatusRequestTimeout,
+					Data:   common.UpstreamTimeoutError(stage),
+					Done:   true,
+				}:
+				case <-ctx.Done():
+				}
+				// 上游已取消, 丢弃剩余帧直至其关闭通道
+				for range in {
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
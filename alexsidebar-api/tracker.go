@@ -0,0 +1,118 @@
+package alexsidebar_api
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SectionDelta 单个内容段相对上一帧的增量
+type SectionDelta struct {
+	Index   int
+	Section Section // 当前帧中该段的完整内容
+	Content string  // 新增内容, Rewrite 时为改写后的完整内容
+	Start   bool    // 该段首次输出内容
+	Rewrite bool    // 已输出的内容被改写而非追加
+}
+
+// Kind 段类型
+func (d SectionDelta) Kind() string {
+	return d.Section.Kind()
+}
+
+// Thinking 是否为思考过程
+func (d SectionDelta) Thinking() bool {
+	return d.Section.Text != nil && d.Section.Text.IsThinking
+}
+
+// SectionTracker 按段索引记录已输出的内容, 逐帧计算全部段的增量
+// 上游每帧携带截至当前的全部 sections, 同一帧可能新增多个段或改写之前的段
+type SectionTracker struct {
+	emitted []trackedSection
+}
+
+type trackedSection struct {
+	kind    string
+	content string
+	pending string // 尚未输出的改写内容, 连续两帧相同或该段结束时输出
+}
+
+// SectionContent 段的可输出内容, 去掉文本末尾未闭合的 </new_ 标记
+func SectionContent(section Section) string {
+	switch {
+	case section.Text != nil:
+		text := strings.TrimSuffix(section.Text.Text, "</new_")
+		return strings.TrimSuffix(text, "</new")
+	case section.Code != nil:
+		return section.Code.Code
+	}
+	return ""
+}
+
+// Update 对比新帧与已输出内容, 按段顺序返回增量
+func (t *SectionTracker) Update(event Event) []SectionDelta {
+	var deltas []SectionDelta
+	for i, section := range event.Sections {
+		kind := section.Kind()
+		content := SectionContent(section)
+		if i >= len(t.emitted) {
+			t.emitted = append(t.emitted, trackedSection{kind: kind})
+		}
+		prev := &t.emitted[i]
+
+		switch {
+		case prev.kind != kind:
+			// 同一位置的段类型变化, 视为新段
+			start := prev.content == ""
+			*prev = trackedSection{kind: kind, content: content}
+			if content != "" {
+				deltas = append(deltas, SectionDelta{Index: i, Section: section, Content: content, Start: true, Rewrite: !start})
+			}
+		case content == prev.content:
+		case strings.HasPrefix(content, prev.content):
+			deltas = append(deltas, SectionDelta{
+				Index:   i,
+				Section: section,
+				Content: content[len(prev.content):],
+				Start:   prev.content == "",
+			})
+			prev.content = content
+		case strings.HasPrefix(prev.content, content):
+			// 内容回退(如去掉末尾空白), 已输出部分保持不变, 等待后续帧超出已输出内容
+		case strings.HasPrefix(content, strings.TrimRightFunc(prev.content, unicode.IsSpace)):
+			// 上游调整了末尾空白, 只输出其后的新内容
+			trimmed := strings.TrimRightFunc(prev.content, unicode.IsSpace)
+			if rest := strings.TrimLeftFunc(content[len(trimmed):], unicode.IsSpace); rest != "" {
+				deltas = append(deltas, SectionDelta{Index: i, Section: section, Content: rest})
+			}
+			prev.content = content
+		case i == len(event.Sections)-1 && (prev.pending == "" || !strings.HasPrefix(content, prev.pending)):
+			// 仍在输出的段被改写, 等到下一帧在改写基础上追加时再输出, 期间恢复为原内容则不输出改写
+			prev.pending = content
+			continue
+		default:
+			deltas = append(deltas, rewriteDelta(i, section, prev, content))
+		}
+		prev.pending = ""
+	}
+	return deltas
+}
+
+// Flush 上游结束时输出暂缓的改写
+func (t *SectionTracker) Flush(event Event) []SectionDelta {
+	var deltas []SectionDelta
+	for i, section := range event.Sections {
+		if i >= len(t.emitted) || t.emitted[i].pending == "" {
+			continue
+		}
+		prev := &t.emitted[i]
+		deltas = append(deltas, rewriteDelta(i, section, prev, prev.pending))
+		prev.pending = ""
+	}
+	return deltas
+}
+
+// rewriteDelta 已输出的内容无法撤回, 输出改写后的完整内容
+func rewriteDelta(index int, section Section, prev *trackedSection, content string) SectionDelta {
+	prev.content = content
+	return SectionDelta{Index: index, Section: section, Content: content, Rewrite: true}
+}
@@ -0,0 +1,103 @@
+package alexsidebar_api
+
+import (
+	"testing"
+)
+
+func textEvent(texts ...string) Event {
+	var event Event
+	for _, text := range texts {
+		event.Sections = append(event.Sections, Section{Text: &TextSection{Text: text}})
+	}
+	return event
+}
+
+// collect 拼接增量, 改写前加换行以便区分
+func collect(deltas []SectionDelta) string {
+	var out string
+	for _, delta := range deltas {
+		if delta.Rewrite {
+			out += "\n"
+		}
+		out += delta.Content
+	}
+	return out
+}
+
+func TestSectionTrackerUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []Event
+		want   []string // 每帧的输出
+		flush  string
+	}{
+		{
+			name:   "prefix growth",
+			frames: []Event{textEvent("Hel"), textEvent("Hello"), textEvent("Hello world")},
+			want:   []string{"Hel", "lo", " world"},
+		},
+		{
+			name:   "identical resend",
+			frames: []Event{textEvent("Hello"), textEvent("Hello"), textEvent("Hello")},
+			want:   []string{"Hello", "", ""},
+		},
+		{
+			name: "mid-text rewrite",
+			frames: []Event{
+				textEvent("The answer is 4"),
+				textEvent("The answer is 5"),
+				textEvent("The answer is 5, because"),
+			},
+			// 改写首次出现时暂缓, 下一帧在改写基础上追加时输出改写后的完整内容
+			want: []string{"The answer is 4", "", "\nThe answer is 5, because"},
+		},
+		{
+			name: "rewrite reverted",
+			frames: []Event{
+				textEvent("Hello wor"),
+				textEvent("Hello WOR"),
+				textEvent("Hello world"),
+			},
+			want: []string{"Hello wor", "", "ld"},
+		},
+		{
+			name: "rewrite of finished section",
+			frames: []Event{
+				textEvent("first"),
+				textEvent("First", "second"),
+			},
+			want: []string{"first", "\nFirst" + "second"},
+		},
+		{
+			name: "rewrite flushed at end",
+			frames: []Event{
+				textEvent("Hello wrld"),
+				textEvent("Hello world"),
+			},
+			want:  []string{"Hello wrld", ""},
+			flush: "\nHello world",
+		},
+		{
+			name: "multibyte rewrite",
+			frames: []Event{
+				textEvent("答案是四"),
+				textEvent("答案是五。"),
+				textEvent("答案是五。因为"),
+			},
+			want: []string{"答案是四", "", "\n答案是五。因为"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tracker SectionTracker
+			for i, frame := range tt.frames {
+				if got := collect(tracker.Update(frame)); got != tt.want[i] {
+					t.Errorf("frame %d: got %q, want %q", i, got, tt.want[i])
+				}
+			}
+			if got := collect(tracker.Flush(tt.frames[len(tt.frames)-1])); got != tt.flush {
+				t.Errorf("flush: got %q, want %q", got, tt.flush)
+			}
+		})
+	}
+}
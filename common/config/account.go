@@ -0,0 +1,129 @@
+package config
+
+import (
+	"alexsidebar2api/common/store"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+// Account 账号附加属性, 以账号ID为 key 持久化
+type Account struct {
+	ID          string `json:"id"`
+	Group       string `json:"group"`
+	Fingerprint string `json:"fingerprint"` // 指纹名称, 为空时使用 DEFAULT_FINGERPRINT
+	DeviceID    string `json:"device_id"`   // 设备标识, 作为 local-id 请求头
+}
+
+var (
+	accountBucket = store.GetBucket("accounts")
+	accountsMutex sync.RWMutex
+	accounts      = map[string]Account{} // cookie -> Account
+)
+
+// AccountID 由 cookie 生成稳定的账号ID, 避免在存储和接口中暴露 cookie
+func AccountID(cookie string) string {
+	hash := sha256.Sum256([]byte(cookie))
+	return hex.EncodeToString(hash[:])[:16]
+}
+
+// parseCookieEntry 解析 AS_COOKIE 中的单项, 支持 "分组:cookie" 格式
+func parseCookieEntry(entry string) (cookie, group string) {
+	entry = strings.TrimSpace(entry)
+	if i := strings.Index(entry, ":"); i > 0 {
+		return strings.TrimSpace(entry[i+1:]), strings.TrimSpace(entry[:i])
+	}
+	return entry, ""
+}
+
+// NewDeviceID 生成设备标识, 格式与客户端的 local-id 一致
+func NewDeviceID() string {
+	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	result := make([]byte, 10)
+	for i := range result {
+		result[i] = charset[rand.Intn(len(charset))]
+	}
+	return string(result)
+}
+
+// loadAccount 加载账号属性, 环境变量中指定的分组优先, 首次加载时生成设备标识
+func loadAccount(cookie, group string) Account {
+	account := Account{ID: AccountID(cookie)}
+	_, _ = accountBucket.Get(account.ID, &account)
+	changed := false
+	if group != "" && group != account.Group {
+		account.Group = group
+		changed = true
+	}
+	if account.DeviceID == "" {
+		account.DeviceID = NewDeviceID()
+		changed = true
+	}
+	if changed {
+		_ = accountBucket.Put(account.ID, account)
+	}
+
+	accountsMutex.Lock()
+	accounts[cookie] = account
+	accountsMutex.Unlock()
+	return account
+}
+
+// AccountDeviceID 获取 cookie 对应账号的设备标识
+func AccountDeviceID(cookie string) string {
+	accountsMutex.Lock()
+	defer accountsMutex.Unlock()
+
+	account, ok := accounts[cookie]
+	if !ok {
+		// 未经 InitASCookies 加载的账号, 在内存中补齐
+		account = Account{ID: AccountID(cookie)}
+	}
+	if account.DeviceID == "" {
+		account.DeviceID = NewDeviceID()
+		accounts[cookie] = account
+	}
+	return account.DeviceID
+}
+
+// GetAccount 获取 cookie 对应的账号属性
+func GetAccount(cookie string) Account {
+	accountsMutex.RLock()
+	defer accountsMutex.RUnlock()
+
+	if account, ok := accounts[cookie]; ok {
+		return account
+	}
+	return Account{ID: AccountID(cookie)}
+}
+
+// ListAccounts 返回当前账号池中全部账号属性
+func ListAccounts() []Account {
+	var result []Account
+	for _, cookie := range GetASCookies() {
+		result = append(result, GetAccount(cookie))
+	}
+	return result
+}
+
+// UpdateAccount 按账号ID更新属性并持久化
+func UpdateAccount(id string, update func(*Account)) (Account, bool, error) {
+	accountsMutex.Lock()
+	defer accountsMutex.Unlock()
+
+	for cookie, account := range accounts {
+		if account.ID != id {
+			continue
+		}
+		update(&account)
+		account.ID = id
+		if err := accountBucket.Put(id, account); err != nil {
+			return account, true, err
+		}
+		accounts[cookie] = account
+		return account, true, nil
+	}
+	return Account{}, false, nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"alexsidebar2api/common/store"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ApiKey 多租户接口密钥
+type ApiKey struct {
+	Key          string   `json:"key"`
+	Name         string   `json:"name"`
+	Models       []string `json:"models"`        // 允许使用的模型, 为空表示不限制
+	AccountGroup string   `json:"account_group"` // 专属账号分组, 为空使用未分组账号, * 使用全部账号
+	RateLimit    int      `json:"rate_limit"`    // 每分钟请求数, 0 表示不限制
+	ExpiresAt    int64    `json:"expires_at"`    // 过期时间(unix秒), 0 表示永不过期
+	CreatedAt    int64    `json:"created_at"`
+}
+
+var apiKeyBucket = store.GetBucket("api_keys")
+
+// IsExpired 判断密钥是否已过期
+func (k ApiKey) IsExpired() bool {
+	return k.ExpiresAt > 0 && time.Now().Unix() >= k.ExpiresAt
+}
+
+// AllowModel 判断密钥是否允许使用该模型
+func (k ApiKey) AllowModel(model string) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+	for _, m := range k.Models {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// HasApiKeys 是否存在持久化的密钥
+func HasApiKeys() bool {
+	return apiKeyBucket.Len() > 0
+}
+
+// GetApiKey 查询持久化的密钥
+func GetApiKey(key string) (ApiKey, bool) {
+	var apiKey ApiKey
+	if key == "" {
+		return apiKey, false
+	}
+	ok, err := apiKeyBucket.Get(key, &apiKey)
+	if err != nil || !ok {
+		return apiKey, false
+	}
+	return apiKey, true
+}
+
+func ListApiKeys() ([]ApiKey, error) {
+	return store.List[ApiKey](apiKeyBucket)
+}
+
+func SaveApiKey(apiKey ApiKey) error {
+	apiKey.Key = strings.TrimSpace(apiKey.Key)
+	if apiKey.Key == "" {
+		return errors.New("key is required")
+	}
+	if apiKey.CreatedAt == 0 {
+		apiKey.CreatedAt = time.Now().Unix()
+	}
+	return apiKeyBucket.Put(apiKey.Key, apiKey)
+}
+
+// DeleteApiKey 删除密钥, 不存在时返回 false
+func DeleteApiKey(key string) (bool, error) {
+	return apiKeyBucket.Delete(key)
+}
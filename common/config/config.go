@@ -21,12 +21,27 @@ var ApiSecret = os.Getenv("API_SECRET")
 var ApiSecrets = strings.Split(os.Getenv("API_SECRET"), ",")
 var UserAgent = env.String("USER_AGENT", "AlexSideBar/190 CFNetwork/1568.300.101 Darwin/24.2.0")
 
+// 上游地址
+var ASBaseURL = strings.TrimSuffix(env.String("AS_BASE_URL", "https://api.alexcodes.app"), "/")
+
+// 内置模拟上游
+var MockUpstreamEnabled = env.Bool("MOCK_UPSTREAM_ENABLE", false)
+var MockUpstreamPort = env.Int("MOCK_UPSTREAM_PORT", 10034)
+var MockUpstreamDelay = env.Int("MOCK_UPSTREAM_DELAY_MS", 50)
+
+// 上游传输实现: cycletls、http、fake、replay
+var UpstreamTransport = env.String("UPSTREAM_TRANSPORT", "cycletls")
+
+// 上游流录制与回放(JSONL)
+var UpstreamCaptureFile = env.String("UPSTREAM_CAPTURE_FILE", "")
+var UpstreamReplayFile = env.String("UPSTREAM_REPLAY_FILE", "")
+
 var RateLimitCookieLockDuration = env.Int("RATE_LIMIT_COOKIE_LOCK_DURATION", 10*60)
 
 // 隐藏思考过程
 var ReasoningHide = env.Int("REASONING_HIDE", 0)
 
-// 前置message
+// 前置message(JSON 数组), 对全部模型生效, 详见 prompt.go
 var PRE_MESSAGES_JSON = env.String("PRE_MESSAGES_JSON", "")
 
 // 路由前缀
@@ -38,7 +53,53 @@ var DebugEnabled = os.Getenv("DEBUG") == "true"
 
 var RateLimitKeyExpirationDuration = 20 * time.Minute
 
-var RequestOutTimeDuration = 5 * time.Minute
+// 单次上游请求的总时长上限
+var RequestOutTimeDuration = time.Duration(env.Int("REQUEST_OUT_TIME", 5*60)) * time.Second
+
+// 上游超时(秒): 建立连接并收到响应头、首帧、相邻两帧间隔, 0 表示不限制
+var (
+	UpstreamConnectTimeout    = env.Int("UPSTREAM_CONNECT_TIMEOUT", 15)
+	UpstreamFirstFrameTimeout = env.Int("UPSTREAM_FIRST_FRAME_TIMEOUT", 60)
+	UpstreamIdleTimeout       = env.Int("UPSTREAM_IDLE_TIMEOUT", 60)
+)
+
+// 上游因自身输出上限截断时自动续写, 单轮输出达到 max_tokens 或以未闭合的修改标记结束时视为截断
+var (
+	AutoContinueEnabled   = env.Bool("AUTO_CONTINUE_ENABLE", false)
+	AutoContinueMaxRounds = env.Int("AUTO_CONTINUE_MAX_ROUNDS", 3)
+)
+
+// 响应缓存: 模型、消息及参数相同的请求直接返回缓存的回复
+// backend 为 memory 或 disk(DATA_PATH 下的 response_cache.json), 超过条目数或总大小(字节)时淘汰最早的条目
+var (
+	ResponseCacheEnabled    = env.Bool("RESPONSE_CACHE_ENABLE", false)
+	ResponseCacheBackend    = env.String("RESPONSE_CACHE_BACKEND", "memory")
+	ResponseCacheTTL        = env.Int("RESPONSE_CACHE_TTL", 3600)
+	ResponseCacheMaxEntries = env.Int("RESPONSE_CACHE_MAX_ENTRIES", 1000)
+	ResponseCacheMaxBytes   = env.Int("RESPONSE_CACHE_MAX_BYTES", 64*1024*1024)
+)
+
+// 合并相同的并发请求, 共享同一个上游请求的输出
+var CoalesceEnabled = env.Bool("COALESCE_ENABLE", false)
+
+// system/developer 消息的处理方式: prompt 合并到上游 prompt 字段, inline 作为 user 消息保留在原位置
+var SystemMessageStrategy = env.String("SYSTEM_MESSAGE_STRATEGY", "prompt")
+
+// 上下文管理: 提示词超过上限时的处理方式 none/drop_oldest/keep_last/summarize
+// 上限为 CONTEXT_MAX_TOKENS(0 表示模型上限)减去预留的输出长度
+var (
+	ContextStrategy     = env.String("CONTEXT_STRATEGY", "none")
+	ContextMaxTokens    = env.Int("CONTEXT_MAX_TOKENS", 0)
+	ContextKeepTurns    = env.Int("CONTEXT_KEEP_TURNS", 10)
+	ContextSummaryModel = env.String("CONTEXT_SUMMARY_MODEL", "deepseek-v3")
+)
+
+// 流式响应心跳: 超过间隔(秒)未收到上游帧时发送, 0 表示关闭
+// 类型 comment 为 SSE 注释行, delta 为空内容的 chunk
+var (
+	StreamHeartbeatInterval = env.Int("STREAM_HEARTBEAT_INTERVAL", 15)
+	StreamHeartbeatType     = env.String("STREAM_HEARTBEAT_TYPE", "comment")
+)
 
 var (
 	RequestRateLimitNum            = env.Int("REQUEST_RATE_LIMIT", 60)
@@ -82,8 +143,8 @@ func InitASCookies() ([]string, error) {
 	cookieStr := os.Getenv("AS_COOKIE")
 	if cookieStr != "" {
 
-		for _, cookie := range strings.Split(cookieStr, ",") {
-			cookie = strings.TrimSpace(cookie)
+		for _, entry := range strings.Split(cookieStr, ",") {
+			cookie, group := parseCookieEntry(entry)
 			request := google_api.RefreshTokenRequest{
 				RefreshToken: cookie,
 			}
@@ -101,12 +162,16 @@ func InitASCookies() ([]string, error) {
 				RefreshToken: response.RefreshToken,
 				AccessToken:  response.AccessToken,
 			}
+			loadAccount(cookie, group)
 			ASCookies = append(ASCookies, cookie)
 		}
 	}
 	return ASCookies, nil
 }
 
+// ErrNoCookies 账号池中没有可用账号
+var ErrNoCookies = errors.New("no cookies available")
+
 type CookieManager struct {
 	Cookies      []string
 	currentIndex int
@@ -125,6 +190,23 @@ func GetASCookies() []string {
 }
 
 func NewCookieManager() *CookieManager {
+	return newCookieManager(func(string) bool { return true })
+}
+
+// AllAccountsGroup 可使用全部账号的分组, 用于环境变量中的密钥
+const AllAccountsGroup = "*"
+
+// NewCookieManagerForGroup 仅包含指定分组账号的 CookieManager, 空分组对应未分组账号
+func NewCookieManagerForGroup(group string) *CookieManager {
+	if group == AllAccountsGroup {
+		return NewCookieManager()
+	}
+	return newCookieManager(func(cookie string) bool {
+		return GetAccount(cookie).Group == group
+	})
+}
+
+func newCookieManager(filter func(cookie string) bool) *CookieManager {
 	var validCookies []string
 	// 遍历 ASCookies
 	for _, cookie := range GetASCookies() {
@@ -133,6 +215,10 @@ func NewCookieManager() *CookieManager {
 			continue // 忽略空字符串
 		}
 
+		if !filter(cookie) {
+			continue
+		}
+
 		// 检查是否在 RateLimitCookies 中
 		if value, ok := rateLimitCookies.Load(cookie); ok {
 			rateLimitCookie, ok := value.(RateLimitCookie) // 正确转换为 RateLimitCookie
@@ -163,7 +249,7 @@ func (cm *CookieManager) GetRandomCookie() (string, error) {
 	defer cm.mu.Unlock()
 
 	if len(cm.Cookies) == 0 {
-		return "", errors.New("no cookies available")
+		return "", ErrNoCookies
 	}
 
 	// 生成随机索引
@@ -179,7 +265,7 @@ func (cm *CookieManager) GetNextCookie() (string, error) {
 	defer cm.mu.Unlock()
 
 	if len(cm.Cookies) == 0 {
-		return "", errors.New("no cookies available")
+		return "", ErrNoCookies
 	}
 
 	cm.currentIndex = (cm.currentIndex + 1) % len(cm.Cookies)
@@ -202,3 +288,18 @@ func RemoveCookie(cookieToRemove string) {
 	// 更新 GSCookies
 	ASCookies = newCookies
 }
+
+// RateLimitRetryAfter 最早恢复可用的限流账号剩余锁定时长, 没有限流账号时返回 0
+func RateLimitRetryAfter() time.Duration {
+	var earliest time.Duration
+	now := time.Now()
+	rateLimitCookies.Range(func(_, value any) bool {
+		if rateLimitCookie, ok := value.(RateLimitCookie); ok {
+			if d := rateLimitCookie.ExpirationTime.Sub(now); d > 0 && (earliest == 0 || d < earliest) {
+				earliest = d
+			}
+		}
+		return true
+	})
+	return earliest
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"alexsidebar2api/common/env"
+	"alexsidebar2api/cycletls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// FingerprintProfile 客户端指纹, TLS 与请求头需保持一致
+type FingerprintProfile struct {
+	Name        string `json:"name"`
+	JA3         string `json:"ja3"`
+	UserAgent   string `json:"user_agent"`
+	ClientType  string `json:"client_type"` // chrome/firefox/safari, 决定 GREASE 及 HTTP/2 头部顺序
+	AppVersion  string `json:"app_version"`
+	BuildNumber string `json:"build_number"`
+	Locale      string `json:"locale"` // accept-language
+}
+
+const DefaultFingerprintName = "default"
+
+// 指纹配置文件(JSON 数组), 未配置时仅有内置的 default
+var FingerprintProfilesFile = env.String("FINGERPRINT_PROFILES_FILE", "")
+
+// 账号未指定指纹时使用的指纹
+var DefaultFingerprint = env.String("DEFAULT_FINGERPRINT", DefaultFingerprintName)
+
+// builtinFingerprint macOS 客户端 2.5.6 (190)
+var builtinFingerprint = FingerprintProfile{
+	Name:        DefaultFingerprintName,
+	JA3:         "771,4865-4866-4867-49196-49195-52393-49200-49199-52392-49162-49161-49172-49171-157-156-53-47-49160-49170-10,0-23-65281-10-11-16-5-13-18-51-45-43-27-21,29-23-24-25,0",
+	UserAgent:   UserAgent,
+	ClientType:  cycletls.ClientSafari, // CFNetwork
+	AppVersion:  "2.5.6",
+	BuildNumber: "190",
+	Locale:      "zh-CN,zh-Hans;q=0.9",
+}
+
+var (
+	fingerprintsMutex sync.RWMutex
+	fingerprints      = map[string]FingerprintProfile{DefaultFingerprintName: builtinFingerprint}
+)
+
+// InitFingerprints 加载指纹配置文件, 未填写的字段沿用内置指纹
+func InitFingerprints() error {
+	if FingerprintProfilesFile == "" {
+		return checkDefaultFingerprint()
+	}
+	data, err := os.ReadFile(FingerprintProfilesFile)
+	if err != nil {
+		return fmt.Errorf("read fingerprint profiles: %v", err)
+	}
+	var profiles []FingerprintProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return fmt.Errorf("parse fingerprint profiles: %v", err)
+	}
+
+	loaded := map[string]FingerprintProfile{DefaultFingerprintName: builtinFingerprint}
+	for _, profile := range profiles {
+		if profile.Name == "" {
+			return fmt.Errorf("fingerprint profile without name")
+		}
+		base := loaded[DefaultFingerprintName]
+		if profile.JA3 == "" {
+			profile.JA3 = base.JA3
+		}
+		if profile.UserAgent == "" {
+			profile.UserAgent = base.UserAgent
+		}
+		if profile.ClientType == "" {
+			profile.ClientType = base.ClientType
+		}
+		switch profile.ClientType {
+		case cycletls.ClientChrome, cycletls.ClientFirefox, cycletls.ClientSafari:
+		default:
+			return fmt.Errorf("fingerprint profile %s: unknown client_type %s", profile.Name, profile.ClientType)
+		}
+		if profile.AppVersion == "" {
+			profile.AppVersion = base.AppVersion
+		}
+		if profile.BuildNumber == "" {
+			profile.BuildNumber = base.BuildNumber
+		}
+		if profile.Locale == "" {
+			profile.Locale = base.Locale
+		}
+		loaded[profile.Name] = profile
+	}
+
+	fingerprintsMutex.Lock()
+	fingerprints = loaded
+	fingerprintsMutex.Unlock()
+	return checkDefaultFingerprint()
+}
+
+func checkDefaultFingerprint() error {
+	if _, ok := GetFingerprint(DefaultFingerprint); !ok {
+		return fmt.Errorf("DEFAULT_FINGERPRINT %s not found", DefaultFingerprint)
+	}
+	return nil
+}
+
+// GetFingerprint 按名称获取指纹
+func GetFingerprint(name string) (FingerprintProfile, bool) {
+	fingerprintsMutex.RLock()
+	defer fingerprintsMutex.RUnlock()
+	profile, ok := fingerprints[name]
+	return profile, ok
+}
+
+// ListFingerprints 按名称排序返回全部指纹
+func ListFingerprints() []FingerprintProfile {
+	fingerprintsMutex.RLock()
+	defer fingerprintsMutex.RUnlock()
+	result := make([]FingerprintProfile, 0, len(fingerprints))
+	for _, profile := range fingerprints {
+		result = append(result, profile)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// AccountFingerprint 返回账号使用的指纹, 账号指定的指纹不存在时使用默认指纹
+func AccountFingerprint(cookie string) FingerprintProfile {
+	if name := GetAccount(cookie).Fingerprint; name != "" {
+		if profile, ok := GetFingerprint(name); ok {
+			return profile
+		}
+	}
+	if profile, ok := GetFingerprint(DefaultFingerprint); ok {
+		return profile
+	}
+	return builtinFingerprint
+}
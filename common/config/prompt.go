@@ -0,0 +1,74 @@
+package config
+
+import (
+	"alexsidebar2api/common/env"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PromptMessage 模板中的预置消息, content 与 OpenAI 消息相同, 可为字符串或内容数组
+type PromptMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+// PromptTemplate 提示词模板, model 为空或 * 时对全部模型生效, 以 * 结尾时按前缀匹配
+type PromptTemplate struct {
+	Model    string          `json:"model"`
+	System   string          `json:"system"`   // 追加在客户端 system 消息之前
+	Messages []PromptMessage `json:"messages"` // 插入在最后一条 system 消息之后
+}
+
+// 提示词模板配置文件(JSON 数组)
+var PromptTemplatesFile = env.String("PROMPT_TEMPLATES_FILE", "")
+
+var promptTemplates []PromptTemplate
+
+// InitPromptTemplates 加载提示词模板, PRE_MESSAGES_JSON 作为最先生效的全局模板
+func InitPromptTemplates() error {
+	var templates []PromptTemplate
+	if PRE_MESSAGES_JSON != "" {
+		var messages []PromptMessage
+		if err := json.Unmarshal([]byte(PRE_MESSAGES_JSON), &messages); err != nil {
+			return fmt.Errorf("parse PRE_MESSAGES_JSON: %v", err)
+		}
+		templates = append(templates, PromptTemplate{Messages: messages})
+	}
+	if PromptTemplatesFile != "" {
+		data, err := os.ReadFile(PromptTemplatesFile)
+		if err != nil {
+			return fmt.Errorf("read prompt templates: %v", err)
+		}
+		var loaded []PromptTemplate
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return fmt.Errorf("parse prompt templates: %v", err)
+		}
+		templates = append(templates, loaded...)
+	}
+	promptTemplates = templates
+	return nil
+}
+
+// PromptTemplatesFor 按配置顺序返回对模型生效的模板
+func PromptTemplatesFor(model string) []PromptTemplate {
+	var result []PromptTemplate
+	for _, template := range promptTemplates {
+		if template.Matches(model) {
+			result = append(result, template)
+		}
+	}
+	return result
+}
+
+// Matches 判断模板是否对模型生效
+func (t PromptTemplate) Matches(model string) bool {
+	switch {
+	case t.Model == "" || t.Model == "*":
+		return true
+	case strings.HasSuffix(t.Model, "*"):
+		return strings.HasPrefix(model, strings.TrimSuffix(t.Model, "*"))
+	}
+	return t.Model == model
+}
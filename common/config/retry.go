@@ -0,0 +1,97 @@
+package config
+
+import (
+	"alexsidebar2api/common/env"
+	"strings"
+)
+
+// RetryAction 上游错误的处理方式
+type RetryAction string
+
+const (
+	RetrySameAccount RetryAction = "same"    // 使用同一账号重试
+	RetrySwitchAcct  RetryAction = "account" // 切换账号重试
+	RetrySwitchProxy RetryAction = "proxy"   // 切换代理重试, 仅有一个代理时切换账号
+	RetryFail        RetryAction = "fail"    // 不重试
+)
+
+// 上游错误类别
+const (
+	ErrorClassUsageLimit          = "usage_limit"
+	ErrorClassNotLogin            = "not_login"
+	ErrorClassRateLimit           = "rate_limit"
+	ErrorClassChineseChat         = "chinese_chat"
+	ErrorClassServerError         = "server_error"
+	ErrorClassCloudflareBlock     = "cloudflare_block"
+	ErrorClassCloudflareChallenge = "cloudflare_challenge"
+	ErrorClassTimeout             = "timeout"
+	ErrorClassNetwork             = "network"
+	ErrorClassUnknown             = "unknown"
+)
+
+var defaultRetryPolicy = map[string]RetryAction{
+	ErrorClassUsageLimit:          RetrySwitchAcct,
+	ErrorClassNotLogin:            RetrySwitchAcct,
+	ErrorClassRateLimit:           RetrySwitchAcct,
+	ErrorClassChineseChat:         RetryFail,
+	ErrorClassServerError:         RetrySameAccount,
+	ErrorClassCloudflareBlock:     RetrySwitchProxy,
+	ErrorClassCloudflareChallenge: RetrySwitchProxy,
+	ErrorClassTimeout:             RetrySwitchAcct,
+	ErrorClassNetwork:             RetrySameAccount,
+	ErrorClassUnknown:             RetryFail,
+}
+
+// RetryPolicy 错误类别到处理方式的映射, 可通过 RETRY_POLICY=server_error=account,network=proxy 覆盖
+var RetryPolicy = parseRetryPolicy(env.String("RETRY_POLICY", ""))
+
+var (
+	RetryMaxAttempts   = env.Int("RETRY_MAX_ATTEMPTS", 5)
+	RetryBackoffBaseMs = env.Int("RETRY_BACKOFF_BASE_MS", 200)
+	RetryBackoffMaxMs  = env.Int("RETRY_BACKOFF_MAX_MS", 5000)
+)
+
+// 流式输出中断后换账号续写, 将已输出内容作为助手消息并追加续写指令
+var (
+	ContinuationFailoverEnabled = env.Bool("CONTINUATION_FAILOVER_ENABLE", false)
+	ContinuationPrompt          = env.String("CONTINUATION_PROMPT", "Your previous reply was cut off. Continue exactly from where it stopped, without repeating any text that was already written and without any preamble.")
+)
+
+// ProxyUrls 代理池, PROXY_URL 可配置多个以,分隔
+var ProxyUrls = splitNonEmpty(ProxyUrl)
+
+func parseRetryPolicy(value string) map[string]RetryAction {
+	policy := make(map[string]RetryAction, len(defaultRetryPolicy))
+	for class, action := range defaultRetryPolicy {
+		policy[class] = action
+	}
+	for _, item := range splitNonEmpty(value) {
+		class, action, ok := strings.Cut(item, "=")
+		if !ok {
+			continue
+		}
+		switch a := RetryAction(strings.ToLower(strings.TrimSpace(action))); a {
+		case RetrySameAccount, RetrySwitchAcct, RetrySwitchProxy, RetryFail:
+			policy[strings.TrimSpace(class)] = a
+		}
+	}
+	return policy
+}
+
+// GetRetryAction 获取错误类别对应的处理方式, 未知类别不重试
+func GetRetryAction(class string) RetryAction {
+	if action, ok := RetryPolicy[class]; ok {
+		return action
+	}
+	return RetryFail
+}
+
+func splitNonEmpty(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
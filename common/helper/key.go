@@ -1,5 +1,6 @@
 package helper
 
 const (
-	RequestIdKey = "X-Request-Id"
+	RequestIdKey     = "X-Request-Id"
+	ApiKeyContextKey = "api_key"
 )
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidKey key 不能作为文件名
+var ErrInvalidKey = errors.New("invalid store key")
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// Collection 每个条目单独保存为 DataPath/<name>/<key>.json, 写入单个条目不会重写其他条目
+// name 可包含 /, 用于按租户等维度分目录
+type Collection struct {
+	name  string
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+var (
+	collections      = map[string]*Collection{}
+	collectionsMutex sync.Mutex
+)
+
+// GetCollection 获取指定名称的 Collection, 同名 Collection 全局唯一
+func GetCollection(name string) *Collection {
+	collectionsMutex.Lock()
+	defer collectionsMutex.Unlock()
+
+	if c, ok := collections[name]; ok {
+		return c
+	}
+	c := &Collection{name: name, locks: map[string]*keyLock{}}
+	collections[name] = c
+	return c
+}
+
+func (c *Collection) dir() string {
+	return filepath.Join(DataPath, filepath.FromSlash(c.name))
+}
+
+func (c *Collection) path(key string) (string, error) {
+	if !keyPattern.MatchString(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(c.dir(), key+".json"), nil
+}
+
+// Lock 锁定单个条目, 用于读改写; 返回的函数解锁
+func (c *Collection) Lock(key string) func() {
+	c.mu.Lock()
+	l, ok := c.locks[key]
+	if !ok {
+		l = &keyLock{}
+		c.locks[key] = l
+	}
+	l.refs++
+	c.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		c.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(c.locks, key)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Get 读取 key 对应的值到 v, 不存在或 key 不合法时返回 false
+func (c *Collection) Get(key string, v any) (bool, error) {
+	path, err := c.path(key)
+	if err != nil {
+		return false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read %s/%s err: %v", c.name, key, err)
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// Put 写入临时文件后重命名, 避免写入中断导致文件损坏
+func (c *Collection) Put(key string, v any) error {
+	path, err := c.path(key)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir(), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir(), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Delete 删除条目, key 不存在时返回 false
+func (c *Collection) Delete(key string) (bool, error) {
+	path, err := c.path(key)
+	if err != nil {
+		return false, nil
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Keys 返回排序后的全部 key
+func (c *Collection) Keys() ([]string, error) {
+	entries, err := os.ReadDir(c.dir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if key, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() && keyPattern.MatchString(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Clear 删除全部条目, 返回删除的数量
+func (c *Collection) Clear() (int, error) {
+	keys, err := c.Keys()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, key := range keys {
+		ok, err := c.Delete(key)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ListCollection 按 key 顺序返回全部值
+func ListCollection[T any](c *Collection) ([]T, error) {
+	keys, err := c.Keys()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]T, 0, len(keys))
+	for _, key := range keys {
+		var item T
+		ok, err := c.Get(key, &item)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,188 @@
+package store
+
+import (
+	"alexsidebar2api/common/env"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DataPath 本地持久化目录
+var DataPath = env.String("DATA_PATH", "data")
+
+// Bucket 以 JSON 文件持久化的键值集合, 每个 Bucket 对应 DataPath 下的一个文件
+type Bucket struct {
+	name   string
+	mu     sync.RWMutex
+	loaded bool
+	items  map[string]json.RawMessage
+}
+
+var (
+	buckets      = map[string]*Bucket{}
+	bucketsMutex sync.Mutex
+)
+
+// GetBucket 获取指定名称的 Bucket, 同名 Bucket 全局唯一
+func GetBucket(name string) *Bucket {
+	bucketsMutex.Lock()
+	defer bucketsMutex.Unlock()
+
+	if b, ok := buckets[name]; ok {
+		return b
+	}
+	b := &Bucket{name: name, items: map[string]json.RawMessage{}}
+	buckets[name] = b
+	return b
+}
+
+func (b *Bucket) path() string {
+	return filepath.Join(DataPath, b.name+".json")
+}
+
+// load 首次访问时从磁盘加载, 调用方需持有写锁
+func (b *Bucket) load() error {
+	if b.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(b.path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			b.loaded = true
+			return nil
+		}
+		return fmt.Errorf("read bucket %s err: %v", b.name, err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &b.items); err != nil {
+			return fmt.Errorf("parse bucket %s err: %v", b.name, err)
+		}
+	}
+	b.loaded = true
+	return nil
+}
+
+// flush 写入临时文件后重命名, 避免写入中断导致文件损坏
+func (b *Bucket) flush() error {
+	if err := os.MkdirAll(DataPath, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(b.items, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := b.path() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path())
+}
+
+// Get 读取 key 对应的值到 v, 不存在时返回 false
+func (b *Bucket) Get(key string, v any) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.load(); err != nil {
+		return false, err
+	}
+	raw, ok := b.items[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Put 写入并持久化
+func (b *Bucket) Put(key string, v any) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.load(); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b.items[key] = raw
+	return b.flush()
+}
+
+// Delete 删除并持久化, key 不存在时返回 false
+func (b *Bucket) Delete(key string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.load(); err != nil {
+		return false, err
+	}
+	if _, ok := b.items[key]; !ok {
+		return false, nil
+	}
+	delete(b.items, key)
+	return true, b.flush()
+}
+
+// Clear 删除全部条目并持久化, 返回删除的数量
+func (b *Bucket) Clear() (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.load(); err != nil {
+		return 0, err
+	}
+	n := len(b.items)
+	b.items = map[string]json.RawMessage{}
+	return n, b.flush()
+}
+
+// Keys 返回排序后的全部 key
+func (b *Bucket) Keys() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(b.items))
+	for k := range b.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len 返回条目数量
+func (b *Bucket) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.load(); err != nil {
+		return 0
+	}
+	return len(b.items)
+}
+
+// List 按 key 顺序返回全部值
+func List[T any](b *Bucket) ([]T, error) {
+	keys, err := b.Keys()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]T, 0, len(keys))
+	for _, key := range keys {
+		var item T
+		ok, err := b.Get(key, &item)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
@@ -212,6 +212,28 @@ func IsServerError(data string) bool {
 	return false
 }
 
+// IsNetworkError 传输层连接或读取失败
+func IsNetworkError(data string) bool {
+	if strings.Contains(data, "Request returned a Syscall Error") || strings.HasPrefix(data, "Error reading stream:") || strings.HasPrefix(data, "-> \n") {
+		return true
+	}
+
+	return false
+}
+
+// UpstreamTimeoutError 上游超时时由传输层生成的错误内容
+func UpstreamTimeoutError(stage string) string {
+	return fmt.Sprintf(`{"error":"Upstream timeout","message":"No response from upstream within the %s timeout."}`, stage)
+}
+
+func IsUpstreamTimeout(data string) bool {
+	if strings.HasPrefix(data, `{"error":"Upstream timeout"`) {
+		return true
+	}
+
+	return false
+}
+
 // 使用 MD5 算法
 func StringToMD5(str string) string {
 	hash := md5.Sum([]byte(str))
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"alexsidebar2api/common"
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/common/random"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// ListApiKeys @Summary 密钥列表
+// @Description 密钥列表
+// @Tags Admin
+// @Produce json
+// @Param Authorization header string true "Authorization BACKEND_SECRET"
+// @Success 200 {object} common.ResponseResult{data=[]config.ApiKey} "成功"
+// @Router /api/keys [get]
+func ListApiKeys(c *gin.Context) {
+	apiKeys, err := config.ListApiKeys()
+	if err != nil {
+		common.SendResponse(c, http.StatusInternalServerError, 1, err.Error(), "")
+		return
+	}
+	common.SendResponse(c, http.StatusOK, 0, "success", apiKeys)
+}
+
+// SaveApiKey @Summary 新增或更新密钥
+// @Description key 为空时自动生成
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param req body config.ApiKey true "密钥"
+// @Param Authorization header string true "Authorization BACKEND_SECRET"
+// @Success 200 {object} common.ResponseResult{data=config.ApiKey} "成功"
+// @Router /api/keys [post]
+func SaveApiKey(c *gin.Context) {
+	var apiKey config.ApiKey
+	if err := c.ShouldBindJSON(&apiKey); err != nil {
+		common.SendResponse(c, http.StatusBadRequest, 1, err.Error(), "")
+		return
+	}
+	if apiKey.Key == "" {
+		apiKey.Key = "sk-" + random.GenerateKey()
+	}
+	if old, ok := config.GetApiKey(apiKey.Key); ok {
+		apiKey.CreatedAt = old.CreatedAt
+	}
+	if err := config.SaveApiKey(apiKey); err != nil {
+		common.SendResponse(c, http.StatusInternalServerError, 1, err.Error(), "")
+		return
+	}
+	apiKey, _ = config.GetApiKey(apiKey.Key)
+	common.SendResponse(c, http.StatusOK, 0, "success", apiKey)
+}
+
+// DeleteApiKey @Summary 删除密钥
+// @Description 删除密钥
+// @Tags Admin
+// @Produce json
+// @Param key path string true "密钥"
+// @Param Authorization header string true "Authorization BACKEND_SECRET"
+// @Success 200 {object} common.ResponseResult "成功"
+// @Router /api/keys/{key} [delete]
+func DeleteApiKey(c *gin.Context) {
+	ok, err := config.DeleteApiKey(c.Param("key"))
+	if err != nil {
+		common.SendResponse(c, http.StatusInternalServerError, 1, err.Error(), "")
+		return
+	}
+	if !ok {
+		common.SendResponse(c, http.StatusNotFound, 1, "key not found", "")
+		return
+	}
+	common.SendResponse(c, http.StatusOK, 0, "success", "")
+}
+
+// ListAccounts @Summary 账号列表
+// @Description 账号列表
+// @Tags Admin
+// @Produce json
+// @Param Authorization header string true "Authorization BACKEND_SECRET"
+// @Success 200 {object} common.ResponseResult{data=[]config.Account} "成功"
+// @Router /api/accounts [get]
+func ListAccounts(c *gin.Context) {
+	common.SendResponse(c, http.StatusOK, 0, "success", config.ListAccounts())
+}
+
+// UpdateAccountRequest 账号更新请求, 未传的字段保持不变
+type UpdateAccountRequest struct {
+	Group       *string `json:"group"`
+	Fingerprint *string `json:"fingerprint"`
+}
+
+// UpdateAccount @Summary 更新账号属性
+// @Description 更新账号分组、指纹
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "账号ID"
+// @Param req body UpdateAccountRequest true "账号属性"
+// @Param Authorization header string true "Authorization BACKEND_SECRET"
+// @Success 200 {object} common.ResponseResult{data=config.Account} "成功"
+// @Router /api/accounts/{id} [put]
+func UpdateAccount(c *gin.Context) {
+	var req UpdateAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.SendResponse(c, http.StatusBadRequest, 1, err.Error(), "")
+		return
+	}
+	if req.Fingerprint != nil && *req.Fingerprint != "" {
+		if _, ok := config.GetFingerprint(*req.Fingerprint); !ok {
+			common.SendResponse(c, http.StatusBadRequest, 1, "fingerprint not found", "")
+			return
+		}
+	}
+	account, ok, err := config.UpdateAccount(c.Param("id"), func(account *config.Account) {
+		if req.Group != nil {
+			account.Group = *req.Group
+		}
+		if req.Fingerprint != nil {
+			account.Fingerprint = *req.Fingerprint
+		}
+	})
+	if !ok {
+		common.SendResponse(c, http.StatusNotFound, 1, "account not found", "")
+		return
+	}
+	if err != nil {
+		common.SendResponse(c, http.StatusInternalServerError, 1, err.Error(), "")
+		return
+	}
+	common.SendResponse(c, http.StatusOK, 0, "success", account)
+}
+
+// RotateAccountDevice @Summary 轮换账号设备标识
+// @Description 为账号生成新的设备标识(local-id)
+// @Tags Admin
+// @Produce json
+// @Param id path string true "账号ID"
+// @Param Authorization header string true "Authorization BACKEND_SECRET"
+// @Success 200 {object} common.ResponseResult{data=config.Account} "成功"
+// @Router /api/accounts/{id}/device/rotate [post]
+func RotateAccountDevice(c *gin.Context) {
+	account, ok, err := config.UpdateAccount(c.Param("id"), func(account *config.Account) {
+		account.DeviceID = config.NewDeviceID()
+	})
+	if !ok {
+		common.SendResponse(c, http.StatusNotFound, 1, "account not found", "")
+		return
+	}
+	if err != nil {
+		common.SendResponse(c, http.StatusInternalServerError, 1, err.Error(), "")
+		return
+	}
+	common.SendResponse(c, http.StatusOK, 0, "success", account)
+}
+
+// ListFingerprints @Summary 指纹列表
+// @Description 查看已加载的客户端指纹
+// @Tags Admin
+// @Produce json
+// @Param Authorization header string true "Authorization BACKEND_SECRET"
+// @Success 200 {object} common.ResponseResult{data=[]config.FingerprintProfile} "成功"
+// @Router /api/fingerprints [get]
+func ListFingerprints(c *gin.Context) {
+	common.SendResponse(c, http.StatusOK, 0, "success", config.ListFingerprints())
+}
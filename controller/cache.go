@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"alexsidebar2api/common"
+	"alexsidebar2api/common/config"
+	logger "alexsidebar2api/common/loggger"
+	"alexsidebar2api/model"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheReplayChunkSize 回放流式缓存时每个数据事件的字符数
+const cacheReplayChunkSize = 16
+
+// 响应头 X-Cache 的取值
+const (
+	cacheStatusHit    = "HIT"
+	cacheStatusMiss   = "MISS"
+	cacheStatusBypass = "BYPASS"
+)
+
+// responseCacheEntry 可缓存的请求, 成功后写入缓存
+type responseCacheEntry struct {
+	key   string
+	model string
+}
+
+// responseCacheKey 以上游实际收到的 prompt、消息文本及影响回复的参数计算缓存键
+// owner 为请求所属的密钥摘要, 不同密钥的缓存互不可见
+func responseCacheKey(openAIReq model.OpenAIChatCompletionRequest, owner string) string {
+	prompt, messages := mapRoles(openAIReq.Messages)
+	type normalizedMessage struct {
+		Role string `json:"role"`
+		Text string `json:"text"`
+	}
+	normalized := struct {
+		Owner         string              `json:"owner"`
+		Model         string              `json:"model"`
+		Prompt        string              `json:"prompt"`
+		Messages      []normalizedMessage `json:"messages"`
+		MaxTokens     int                 `json:"max_tokens"`
+		Temperature   float64             `json:"temperature"`
+		ReasoningHide int                 `json:"reasoning_hide"`
+	}{
+		Owner:         owner,
+		Model:         openAIReq.Model,
+		Prompt:        strings.TrimSpace(prompt),
+		MaxTokens:     openAIReq.MaxTokens,
+		Temperature:   openAIReq.Temperature,
+		ReasoningHide: config.ReasoningHide,
+	}
+	if normalized.MaxTokens <= 1 {
+		normalized.MaxTokens = defaultMaxTokens
+	}
+	for _, message := range messages {
+		normalized.Messages = append(normalized.Messages, normalizedMessage{Role: message.Role, Text: strings.TrimSpace(message.TextContent())})
+	}
+	data, _ := json.Marshal(normalized)
+	return common.StringToSHA256(string(data))
+}
+
+// lookupResponseCache 查询缓存并设置 X-Cache 响应头
+// 请求头 Cache-Control: no-cache 跳过读取但仍写入, no-store 既不读取也不写入
+// 声明了 tools 或开启 code_blocks 的请求回复中含结构化内容, 不缓存
+func lookupResponseCache(c *gin.Context, openAIReq model.OpenAIChatCompletionRequest) (*responseCacheEntry, *model.CachedResponse) {
+	if !config.ResponseCacheEnabled {
+		return nil, nil
+	}
+	cacheControl := strings.ToLower(c.GetHeader("Cache-Control"))
+	if len(openAIReq.Tools) > 0 || openAIReq.CodeBlocks || strings.Contains(cacheControl, "no-store") {
+		c.Header("X-Cache", cacheStatusBypass)
+		return nil, nil
+	}
+
+	entry := &responseCacheEntry{key: responseCacheKey(openAIReq, conversationOwner(c)), model: openAIReq.Model}
+	c.Header("X-Cache-Key", entry.key)
+	if strings.Contains(cacheControl, "no-cache") {
+		c.Header("X-Cache", cacheStatusBypass)
+		return entry, nil
+	}
+	cached, ok, err := model.GetCachedResponse(entry.key)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "get cached response err: %v", err)
+	}
+	if !ok {
+		c.Header("X-Cache", cacheStatusMiss)
+		return entry, nil
+	}
+	c.Header("X-Cache", cacheStatusHit)
+	c.Header("Age", strconv.FormatInt(time.Now().Unix()-cached.CreatedAt, 10))
+	return entry, &cached
+}
+
+// save 缓存正常结束的回复, 被截断或失败的回复不缓存
+func (e *responseCacheEntry) save(c *gin.Context, output, finishReason string) {
+	if e == nil || finishReason != "stop" || output == "" {
+		return
+	}
+	err := model.PutCachedResponse(model.CachedResponse{Key: e.key, Model: e.model, Content: output, FinishReason: finishReason})
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "save cached response err: %v", err)
+	}
+}
+
+// replayCachedResponse 返回缓存的回复, 流式请求按固定长度重新分块
+func replayCachedResponse(c *gin.Context, openAIReq model.OpenAIChatCompletionRequest, cached *model.CachedResponse) {
+	responseId := newResponseID()
+	if !openAIReq.Stream {
+		replayFinish(c, nil, responseId, openAIReq, cached.Content, cached.FinishReason)
+		return
+	}
+
+	session := newStreamSession(c)
+	if err := session.send(replayChunk(responseId, openAIReq.Model, model.OpenAIDelta{Role: "assistant"}, nil)); err != nil {
+		return
+	}
+	content := []rune(cached.Content)
+	for i := 0; i < len(content); i += cacheReplayChunkSize {
+		part := string(content[i:min(i+cacheReplayChunkSize, len(content))])
+		if err := session.sendContent(replayChunk(responseId, openAIReq.Model, model.OpenAIDelta{Role: "assistant", Content: part}, nil)); err != nil {
+			return
+		}
+	}
+	replayFinish(c, session, responseId, openAIReq, cached.Content, cached.FinishReason)
+}
+
+// replayChunk 不经过上游的回复使用的流式 chunk
+func replayChunk(responseId, modelName string, delta model.OpenAIDelta, finishReason *string) model.OpenAIChatCompletionResponse {
+	return model.OpenAIChatCompletionResponse{
+		ID:      responseId,
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   modelName,
+		Choices: []model.OpenAIChoice{{Delta: delta, FinishReason: finishReason}},
+	}
+}
+
+// replayFinish 结束不经过上游的回复, session 为 nil 时返回完整的非流式响应
+func replayFinish(c *gin.Context, session *streamSession, responseId string, openAIReq model.OpenAIChatCompletionRequest, content, finishReason string) {
+	promptTokens := model.CountTokenMessages(openAIReq.Messages, openAIReq.Model)
+	completionTokens := model.CountTokenText(content, openAIReq.Model)
+	usage := model.OpenAIUsage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+
+	if session == nil {
+		c.JSON(http.StatusOK, model.OpenAIChatCompletionResponse{
+			ID:      responseId,
+			Object:  "chat.completion",
+			Created: time.Now().Unix(),
+			Model:   openAIReq.Model,
+			Choices: []model.OpenAIChoice{{
+				Message:      model.OpenAIMessage{Role: "assistant", Content: content},
+				FinishReason: &finishReason,
+			}},
+			Usage:          usage,
+			ConversationID: openAIReq.ConversationID,
+		})
+		return
+	}
+	last := replayChunk(responseId, openAIReq.Model, model.OpenAIDelta{Role: "assistant"}, &finishReason)
+	last.Usage = usage
+	if err := session.send(last); err != nil {
+		return
+	}
+	session.done()
+}
+
+// GetResponseCacheStats @Summary 响应缓存统计
+// @Description 缓存后端、条目数及总大小
+// @Tags Admin
+// @Produce json
+// @Param Authorization header string true "Authorization BACKEND_SECRET"
+// @Success 200 {object} common.ResponseResult{data=model.ResponseCacheStats} "成功"
+// @Router /api/cache [get]
+func GetResponseCacheStats(c *gin.Context) {
+	stats, err := model.GetResponseCacheStats()
+	if err != nil {
+		common.SendResponse(c, http.StatusInternalServerError, 1, err.Error(), "")
+		return
+	}
+	common.SendResponse(c, http.StatusOK, 0, "success", stats)
+}
+
+// DeleteCachedResponse @Summary 删除缓存
+// @Description 按响应头 X-Cache-Key 返回的缓存键删除缓存
+// @Tags Admin
+// @Produce json
+// @Param key path string true "缓存键"
+// @Param Authorization header string true "Authorization BACKEND_SECRET"
+// @Success 200 {object} common.ResponseResult "成功"
+// @Router /api/cache/{key} [delete]
+func DeleteCachedResponse(c *gin.Context) {
+	ok, err := model.DeleteCachedResponse(c.Param("key"))
+	if err != nil {
+		common.SendResponse(c, http.StatusInternalServerError, 1, err.Error(), "")
+		return
+	}
+	if !ok {
+		common.SendResponse(c, http.StatusNotFound, 1, "cache not found", "")
+		return
+	}
+	common.SendResponse(c, http.StatusOK, 0, "success", "")
+}
+
+// PurgeResponseCache @Summary 清空缓存
+// @Description 清空全部响应缓存, 返回删除的数量
+// @Tags Admin
+// @Produce json
+// @Param Authorization header string true "Authorization BACKEND_SECRET"
+// @Success 200 {object} common.ResponseResult{data=int} "成功"
+// @Router /api/cache [delete]
+func PurgeResponseCache(c *gin.Context) {
+	n, err := model.PurgeResponseCache()
+	if err != nil {
+		common.SendResponse(c, http.StatusInternalServerError, 1, err.Error(), "")
+		return
+	}
+	common.SendResponse(c, http.StatusOK, 0, "success", n)
+}
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	alexsidebar_api "alexsidebar2api/alexsidebar-api"
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/common/helper"
+	"alexsidebar2api/model"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestResponseCacheTenantIsolation 相同请求只命中同一密钥写入的缓存
+func TestResponseCacheTenantIsolation(t *testing.T) {
+	defer func(enabled bool) { config.ResponseCacheEnabled = enabled }(config.ResponseCacheEnabled)
+	config.ResponseCacheEnabled = true
+	defer model.PurgeResponseCache()
+
+	fake := &alexsidebar_api.FakeClient{
+		Frames: []string{`{"sections":[{"text":{"text":"Hello"}}]}`},
+		Record: true,
+	}
+	alexsidebar_api.SetDefaultClient(fake)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(helper.ApiKeyContextKey, config.ApiKey{Key: c.GetHeader("X-Test-Key")})
+	})
+	router.POST("/v1/chat/completions", ChatForOpenAI)
+
+	body := `{"model":"claude-3-7-sonnet","messages":[{"role":"user","content":"hi"}]}`
+	tests := []struct {
+		key   string
+		cache string
+	}{
+		{"sk-a", cacheStatusMiss},
+		{"sk-a", cacheStatusHit},
+		{"sk-b", cacheStatusMiss},
+		{"sk-b", cacheStatusHit},
+	}
+	keys := map[string]string{}
+	for i, tt := range tests {
+		rec := doChatWithHeaders(router, body, map[string]string{"X-Test-Key": tt.key})
+		if got := rec.Header().Get("X-Cache"); got != tt.cache {
+			t.Errorf("request %d with %s: expected X-Cache %s, got %s", i, tt.key, tt.cache, got)
+		}
+		keys[tt.key] = rec.Header().Get("X-Cache-Key")
+	}
+	if keys["sk-a"] == keys["sk-b"] {
+		t.Errorf("different keys share cache key %s", keys["sk-a"])
+	}
+	if len(fake.Requests) != 2 {
+		t.Errorf("expected 2 upstream requests, got %d", len(fake.Requests))
+	}
+}
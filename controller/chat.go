@@ -4,9 +4,12 @@ import (
 	"alexsidebar2api/alexsidebar-api"
 	"alexsidebar2api/common"
 	"alexsidebar2api/common/config"
+	"alexsidebar2api/common/helper"
 	logger "alexsidebar2api/common/loggger"
+	"alexsidebar2api/common/random"
 	"alexsidebar2api/cycletls"
 	"alexsidebar2api/model"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
@@ -20,6 +23,8 @@ import (
 const (
 	errServerErrMsg  = "Service Unavailable"
 	responseIDFormat = "chatcmpl-%s"
+	// 客户端未指定 max_tokens 时的输出上限
+	defaultMaxTokens = 8000
 )
 
 // ChatForOpenAI @Summary OpenAI对话接口
@@ -31,89 +36,223 @@ const (
 // @Param Authorization header string true "Authorization API-KEY"
 // @Router /v1/chat/completions [post]
 func ChatForOpenAI(c *gin.Context) {
-	client := cycletls.Init()
-	defer safeClose(client)
+	client, err := alexsidebar_api.DefaultClient()
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "DefaultClient err: %v", err)
+		sendError(c, translateError(err))
+		return
+	}
 
 	var openAIReq model.OpenAIChatCompletionRequest
-	if err := c.BindJSON(&openAIReq); err != nil {
+	if err := c.ShouldBindJSON(&openAIReq); err != nil {
 		logger.Errorf(c.Request.Context(), err.Error())
-		c.JSON(http.StatusInternalServerError, model.OpenAIErrorResponse{
-			OpenAIError: model.OpenAIError{
-				Message: "Invalid request parameters",
-				Type:    "request_error",
-				Code:    "500",
-			},
-		})
+		sendError(c, model.NewAPIError(http.StatusBadRequest, "invalid_request_body",
+			fmt.Sprintf("Invalid request parameters: %v", err)))
 		return
 	}
 
 	openAIReq.RemoveEmptyContentMessages()
 
-	modelInfo, b := common.GetModelInfo(openAIReq.Model)
-	if !b {
-		c.JSON(http.StatusBadRequest, model.OpenAIErrorResponse{
-			OpenAIError: model.OpenAIError{
-				Message: fmt.Sprintf("Model %s not supported", openAIReq.Model),
-				Type:    "invalid_request_error",
-				Code:    "invalid_model",
-			},
-		})
+	apiKey := getApiKey(c)
+	modelInfo, err := validateChatRequest(openAIReq, apiKey)
+	if err != nil {
+		sendError(c, translateError(err))
 		return
 	}
-	if openAIReq.MaxTokens > modelInfo.MaxTokens {
-		c.JSON(http.StatusBadRequest, model.OpenAIErrorResponse{
-			OpenAIError: model.OpenAIError{
-				Message: fmt.Sprintf("Max tokens %d exceeds limit %d", openAIReq.MaxTokens, modelInfo.MaxTokens),
-				Type:    "invalid_request_error",
-				Code:    "invalid_max_tokens",
-			},
-		})
+
+	turn, err := loadConversationTurn(c, &openAIReq)
+	if err != nil {
+		sendError(c, translateError(err))
+		return
+	}
+	if turn != nil {
+		c.Header("X-Conversation-Id", turn.id)
+	}
+
+	applyPromptTemplates(&openAIReq, apiKey)
+
+	cache, cached := lookupResponseCache(c, openAIReq)
+	if cached != nil {
+		replayCachedResponse(c, openAIReq, cached)
+		turn.save(c, openAIReq.Model, cached.Content)
+		return
+	}
+
+	flight, leader := joinFlight(c, openAIReq, apiKey)
+	if flight != nil && !leader {
+		output, ok := followFlight(c, openAIReq, flight)
+		flight.unsubscribe()
+		if ok {
+			if output != "" {
+				turn.save(c, openAIReq.Model, output)
+			}
+			return
+		}
+		flight = nil
+	}
+	defer flight.close()
+	done := completion{turn: turn, cache: cache, flight: flight}
+
+	report, err := manageContext(c, client, &openAIReq, modelInfo, apiKey.AccountGroup)
+	if report != nil {
+		c.Header("X-Context-Management", report.String())
+	}
+	if err != nil {
+		sendError(c, translateError(err))
 		return
 	}
 
 	if openAIReq.Stream {
-		handleStreamRequest(c, client, openAIReq, modelInfo)
+		handleStreamRequest(c, client, openAIReq, modelInfo, apiKey.AccountGroup, done)
 	} else {
-		handleNonStreamRequest(c, client, openAIReq, modelInfo)
+		handleNonStreamRequest(c, client, openAIReq, modelInfo, apiKey.AccountGroup, done)
 	}
 }
 
-func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) {
-	ctx := c.Request.Context()
-	cookieManager := config.NewCookieManager()
-	maxRetries := len(cookieManager.Cookies)
-	cookie, err := cookieManager.GetRandomCookie()
+// validateChatRequest 校验密钥的模型权限、模型及 max_tokens
+func validateChatRequest(openAIReq model.OpenAIChatCompletionRequest, apiKey config.ApiKey) (common.ModelInfo, error) {
+	if !apiKey.AllowModel(openAIReq.Model) {
+		return common.ModelInfo{}, model.NewAPIError(http.StatusForbidden, "model_not_allowed",
+			fmt.Sprintf("Model %s not allowed for this API key", openAIReq.Model))
+	}
+	modelInfo, ok := common.GetModelInfo(openAIReq.Model)
+	if !ok {
+		return common.ModelInfo{}, model.NewAPIError(http.StatusNotFound, "model_not_found",
+			fmt.Sprintf("Model %s not supported", openAIReq.Model))
+	}
+	if openAIReq.MaxTokens > modelInfo.MaxTokens {
+		return common.ModelInfo{}, model.NewAPIError(http.StatusBadRequest, "invalid_max_tokens",
+			fmt.Sprintf("Max tokens %d exceeds limit %d", openAIReq.MaxTokens, modelInfo.MaxTokens))
+	}
+	return modelInfo, nil
+}
+
+// PreviewChat @Summary 预览提示词
+// @Description 与对话接口相同的请求, 返回应用会话历史、提示词模板、上下文管理及角色映射后发往上游的请求, 除 summarize 策略的摘要外不请求上游
+// @Tags OpenAI
+// @Accept json
+// @Produce json
+// @Param req body model.OpenAIChatCompletionRequest true "OpenAI对话请求"
+// @Param Authorization header string true "Authorization API-KEY"
+// @Success 200 {object} model.ChatPreviewResponse "成功"
+// @Router /v1/chat/completions/preview [post]
+func PreviewChat(c *gin.Context) {
+	client, err := alexsidebar_api.DefaultClient()
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "DefaultClient err: %v", err)
+		sendError(c, translateError(err))
+		return
+	}
+
+	var openAIReq model.OpenAIChatCompletionRequest
+	if err := c.ShouldBindJSON(&openAIReq); err != nil {
+		sendError(c, model.NewAPIError(http.StatusBadRequest, "invalid_request_body",
+			fmt.Sprintf("Invalid request parameters: %v", err)))
+		return
+	}
+	openAIReq.RemoveEmptyContentMessages()
+
+	apiKey := getApiKey(c)
+	modelInfo, err := validateChatRequest(openAIReq, apiKey)
+	if err != nil {
+		sendError(c, translateError(err))
+		return
+	}
+	if _, err := loadConversationTurn(c, &openAIReq); err != nil {
+		sendError(c, translateError(err))
+		return
+	}
+	templates := applyPromptTemplates(&openAIReq, apiKey)
+
+	report, err := manageContext(c, client, &openAIReq, modelInfo, apiKey.AccountGroup)
+	if report != nil {
+		c.Header("X-Context-Management", report.String())
+	}
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		sendError(c, translateError(err))
 		return
 	}
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		requestBody, err := createRequestBody(c, &openAIReq, modelInfo)
+
+	requestBody, err := createRequestBody(c, &openAIReq, modelInfo)
+	if err != nil {
+		sendError(c, translateError(err))
+		return
+	}
+	var contextManagement string
+	if report != nil {
+		contextManagement = report.String()
+	}
+	c.JSON(http.StatusOK, model.ChatPreviewResponse{
+		Object:            "chat.completion.preview",
+		Model:             openAIReq.Model,
+		Templates:         templates,
+		ContextManagement: contextManagement,
+		Messages:          openAIReq.Messages,
+		PromptTokens:      model.CountTokenMessages(openAIReq.Messages, openAIReq.Model),
+		UpstreamRequest:   requestBody,
+	})
+}
+
+// completion 回复正常结束后保存会话、写入缓存并通知合并的请求
+type completion struct {
+	turn   *conversationTurn
+	cache  *responseCacheEntry
+	flight *flight
+}
+
+func (d completion) finish(c *gin.Context, modelName, output, finishReason string) {
+	d.turn.save(c, modelName, output)
+	d.cache.save(c, output, finishReason)
+	d.flight.finish(output, finishReason)
+}
+
+// newResponseID 响应 id, 同一秒内的响应(如合并请求的各个跟随者)以随机后缀区分
+func newResponseID() string {
+	return fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405")+random.GetRandomString(6))
+}
+
+func handleNonStreamRequest(c *gin.Context, client alexsidebar_api.Client, openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo, accountGroup string, done completion) {
+	ctx := done.flight.upstreamContext(c)
+	retry, err := newRetryState(ctx, accountGroup)
+	if err != nil {
+		sendError(c, translateError(err))
+		return
+	}
+	auto := newAutoContinue(openAIReq, modelInfo)
+	// 上游截断后续写时的上一轮渲染器
+	var previous *sectionRenderer
+	for {
+		req := openAIReq
+		if previous != nil {
+			req = continuationRequest(openAIReq, visibleOutput(previous.output.String()))
+		}
+		requestBody, err := createRequestBody(c, &req, modelInfo)
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			sendError(c, model.NewAPIError(http.StatusBadRequest, "invalid_request", err.Error()))
 			return
 		}
 
 		jsonData, err := json.Marshal(requestBody)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to marshal request body"})
+			sendError(c, translateError(err))
 			return
 		}
-		sseChan, err := alexsidebar_api.MakeStreamChatRequest(c, client, jsonData, cookie)
+		// 每次尝试独立的上下文, 重试前关闭上一次的上游连接
+		attemptCtx, cancelAttempt := context.WithCancel(ctx)
+		sseChan, err := alexsidebar_api.MakeStreamChatRequest(attemptCtx, client, jsonData, retry.cookie, retry.proxy())
 		if err != nil {
-			logger.Errorf(ctx, "MakeStreamChatRequest err on attempt %d: %v", attempt+1, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			logger.Errorf(ctx, "MakeStreamChatRequest err on attempt %d: %v", retry.attempt+1, err)
+			sendError(c, model.NewAPIError(http.StatusBadGateway, "upstream_error", err.Error()))
+			cancelAttempt()
 			return
 		}
 
-		isRateLimit := false
-		var delta string
-		var assistantMsgContent string
-		var shouldContinue bool
-		thinkStartType := new(bool)
-		thinkEndType := new(bool)
-		var lastText string
-		var lastCode string
+		var failure string
+		renderer := newSectionRenderer(openAIReq)
+		if previous != nil {
+			renderer.continueFrom(previous)
+		}
+		continued := false
 	SSELoop:
 		for response := range sseChan {
 			data := response.Data
@@ -122,94 +261,84 @@ func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, openAIReq
 			}
 
 			if response.Done && data != "[DONE]" {
-				switch {
-				case common.IsUsageLimitExceeded(data):
-					isRateLimit = true
-					logger.Warnf(ctx, "Cookie Usage limit exceeded, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-					config.RemoveCookie(cookie)
-					break SSELoop
-				case common.IsChineseChat(data):
-					logger.Errorf(ctx, data)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Detected that you are using Chinese for conversation, please use English for conversation."})
-					return
-				case common.IsNotLogin(data):
-					isRateLimit = true
-					logger.Warnf(ctx, "Cookie Not Login, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-					break SSELoop
-				case common.IsRateLimit(data):
-					isRateLimit = true
-					logger.Warnf(ctx, "Cookie rate limited, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-					config.AddRateLimitCookie(cookie, time.Now().Add(time.Duration(config.RateLimitCookieLockDuration)*time.Second))
-					break SSELoop
-				}
-				logger.Warnf(ctx, response.Data)
-				return
+				failure = classifyUpstreamError(response)
+				logger.Warnf(ctx, "Upstream error %s on attempt %d, COOKIE:%s, data: %s", failure, retry.attempt+1, retry.cookie, data)
+				break SSELoop
 			}
 
 			logger.Debug(ctx, strings.TrimSpace(data))
 
-			streamDelta, streamShouldContinue := processNoStreamData(c, data, thinkStartType, thinkEndType, &lastText, &lastCode)
-			delta = streamDelta
-			shouldContinue = streamShouldContinue
+			if data == "[DONE]" && auto.next(renderer, jsonData, openAIReq.Model) {
+				logger.Warnf(ctx, "Upstream output cut off, auto continuing round %d", auto.rounds)
+				continued = true
+				break SSELoop
+			}
+
 			// 处理事件流数据
+			_, shouldContinue, err := processNoStreamData(c, data, renderer)
+			if err != nil {
+				// 上游帧无法解析时回复不完整, 不缓存、不保存会话
+				sendError(c, model.NewAPIError(http.StatusBadGateway, "invalid_upstream_response", err.Error()))
+				cancelAttempt()
+				return
+			}
 			if !shouldContinue {
-				promptTokens := model.CountTokenText(string(jsonData), openAIReq.Model)
-				completionTokens := model.CountTokenText(assistantMsgContent, openAIReq.Model)
-				finishReason := "stop"
+				finishReason := renderer.finishReason()
 
 				c.JSON(http.StatusOK, model.OpenAIChatCompletionResponse{
-					ID:      fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405")),
+					ID:      newResponseID(),
 					Object:  "chat.completion",
 					Created: time.Now().Unix(),
 					Model:   openAIReq.Model,
 					Choices: []model.OpenAIChoice{{
 						Message: model.OpenAIMessage{
-							Role:    "assistant",
-							Content: assistantMsgContent,
+							Role:       "assistant",
+							Content:    renderer.output.String(),
+							CodeBlocks: renderer.codeBlocks(),
+							ToolCalls:  renderer.takeToolCalls(),
 						},
 						FinishReason: &finishReason,
 					}},
-					Usage: model.OpenAIUsage{
-						PromptTokens:     promptTokens,
-						CompletionTokens: completionTokens,
-						TotalTokens:      promptTokens + completionTokens,
-					},
+					Usage:          auto.usage(renderer, jsonData, openAIReq.Model),
+					ConversationID: openAIReq.ConversationID,
 				})
-
+				done.finish(c, openAIReq.Model, renderer.output.String(), finishReason)
+				cancelAttempt()
 				return
-			} else {
-				assistantMsgContent = assistantMsgContent + delta
 			}
 		}
-		if !isRateLimit {
-			return
+		cancelAttempt()
+		if continued {
+			previous = renderer
+			continue
+		}
+		if failure == "" {
+			// 客户端已断开(合并请求时为全部客户端)
+			if ctx.Err() != nil {
+				return
+			}
+			// 上游未发送结束帧即断开, 视为网络错误
+			failure = config.ErrorClassNetwork
+			logger.Warnf(ctx, "Upstream stream closed without [DONE] on attempt %d", retry.attempt+1)
 		}
 
-		// 获取下一个可用的cookie继续尝试
-		cookie, err = cookieManager.GetNextCookie()
-		if err != nil {
-			logger.Errorf(ctx, "No more valid cookies available after attempt %d", attempt+1)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		// 按重试策略切换账号/代理或放弃
+		if err := retry.next(failure); err != nil {
+			logger.Errorf(ctx, "Giving up after attempt %d: %v", retry.attempt, err)
+			sendError(c, translateError(err))
 			return
 		}
-
 	}
-	logger.Errorf(ctx, "All cookies exhausted after %d attempts", maxRetries)
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "All cookies are temporarily unavailable."})
-	return
 }
 
 func createRequestBody(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) (map[string]interface{}, error) {
-	client := cycletls.Init()
-	defer safeClose(client)
-
 	if openAIReq.MaxTokens <= 1 {
-		openAIReq.MaxTokens = 8000
+		openAIReq.MaxTokens = defaultMaxTokens
 	}
 
 	logger.Debug(c.Request.Context(), fmt.Sprintf("RequestBody: %v", openAIReq))
 
-	systemContent := openAIReq.GetFirstSystemContent()
+	systemContent, mappedMessages := mapRoles(openAIReq.Messages)
 
 	requestBody := map[string]interface{}{
 		"model":  modelInfo.Model,
@@ -227,7 +356,7 @@ func createRequestBody(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequ
 
 	isThinkingModel := strings.HasSuffix(openAIReq.Model, "-thinking")
 
-	for i, msg := range openAIReq.Messages {
+	for i, msg := range mappedMessages {
 		// 将角色转换为首字母大写的格式
 		formattedRole := capitalizeRole(msg.Role)
 
@@ -243,7 +372,7 @@ func createRequestBody(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequ
 			"think_first":    false,
 		}
 
-		if isThinkingModel && msg.Role == "user" && i == len(openAIReq.Messages)-1 {
+		if isThinkingModel && msg.Role == "user" && i == len(mappedMessages)-1 {
 			formattedMsg["think_first"] = true
 		}
 
@@ -284,22 +413,12 @@ func createRequestBody(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequ
 	return requestBody, nil
 }
 
-// 将角色转换为首字母大写的格式
+// capitalizeRole 将角色转换为上游的格式, mapRoles 之后只有 user/assistant 两种角色
 func capitalizeRole(role string) string {
-	switch strings.ToLower(role) {
-	case "user":
-		return "User"
-	case "assistant":
+	if role == "assistant" {
 		return "Assistant"
-	case "system":
-		return "System"
-	default:
-		// 对于未知角色，也尝试首字母大写
-		if len(role) > 0 {
-			return strings.ToUpper(role[:1]) + role[1:]
-		}
-		return role
 	}
+	return "User"
 }
 
 // createStreamResponse 创建流式响应
@@ -327,7 +446,7 @@ func createStreamResponse(responseId, modelName string, jsonData []byte, delta m
 }
 
 // handleDelta 处理消息字段增量
-func handleDelta(c *gin.Context, delta string, responseId, modelName string, jsonData []byte) error {
+func handleDelta(session *streamSession, delta string, responseId, modelName string, jsonData []byte) error {
 	// 创建基础响应
 	createResponse := func(content string) model.OpenAIChatCompletionResponse {
 		return createStreamResponse(
@@ -341,380 +460,232 @@ func handleDelta(c *gin.Context, delta string, responseId, modelName string, jso
 
 	// 发送基础事件
 	var err error
-	if err = sendSSEvent(c, createResponse(delta)); err != nil {
+	if err = session.sendContent(createResponse(delta)); err != nil {
 		return err
 	}
+	session.flight.publish(delta)
 
 	return err
 }
 
-// handleMessageResult 处理消息结果
-func handleMessageResult(c *gin.Context, responseId, modelName string, jsonData []byte) bool {
-	finishReason := "stop"
-	var delta string
+// handleMessageResult 处理消息结果, 开启 code_blocks 扩展时随结束事件返回代码块, 结束事件携带各轮用量之和
+func handleMessageResult(session *streamSession, responseId, modelName string, jsonData []byte, renderer *sectionRenderer, usage model.OpenAIUsage) bool {
+	finishReason := renderer.finishReason()
 
-	promptTokens := 0
-	completionTokens := 0
+	streamResp := createStreamResponse(responseId, modelName, jsonData, model.OpenAIDelta{Role: "assistant", CodeBlocks: renderer.codeBlocks()}, &finishReason)
+	streamResp.Usage = usage
 
-	streamResp := createStreamResponse(responseId, modelName, jsonData, model.OpenAIDelta{Content: delta, Role: "assistant"}, &finishReason)
-	streamResp.Usage = model.OpenAIUsage{
-		PromptTokens:     promptTokens,
-		CompletionTokens: completionTokens,
-		TotalTokens:      promptTokens + completionTokens,
-	}
-
-	if err := sendSSEvent(c, streamResp); err != nil {
-		logger.Warnf(c.Request.Context(), "sendSSEvent err: %v", err)
+	if err := session.send(streamResp); err != nil {
+		logger.Warnf(session.c.Request.Context(), "send stream response err: %v", err)
 		return false
 	}
-	c.SSEvent("", " [DONE]")
+	session.done()
 	return false
 }
 
-// sendSSEvent 发送SSE事件
-func sendSSEvent(c *gin.Context, response model.OpenAIChatCompletionResponse) error {
-	jsonResp, err := json.Marshal(response)
-	if err != nil {
-		logger.Errorf(c.Request.Context(), "Failed to marshal response: %v", err)
-		return err
-	}
-	c.SSEvent("", " "+string(jsonResp))
-	c.Writer.Flush()
-	return nil
-}
-
-func handleStreamRequest(c *gin.Context, client cycletls.CycleTLS, openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) {
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-
-	responseId := fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405"))
-	ctx := c.Request.Context()
+func handleStreamRequest(c *gin.Context, client alexsidebar_api.Client, openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo, accountGroup string, done completion) {
+	responseId := newResponseID()
+	ctx := done.flight.upstreamContext(c)
+	session := newStreamSession(c)
+	session.flight = done.flight
 
-	cookieManager := config.NewCookieManager()
-	maxRetries := len(cookieManager.Cookies)
-	cookie, err := cookieManager.GetRandomCookie()
+	retry, err := newRetryState(ctx, accountGroup)
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		session.fail(translateError(err))
 		return
 	}
 
-	thinkStartType := new(bool)
-	thinkEndType := new(bool)
-
-	// 为每个请求创建独立的状态变量
-	var lastText string
-	var lastCode string
+	// 立即发送角色, 客户端在首个内容到达前即可确认连接正常
+	if err := session.send(createStreamResponse(responseId, openAIReq.Model, nil, model.OpenAIDelta{Role: "assistant"}, nil)); err != nil {
+		logger.Warnf(ctx, "send role chunk err: %v", err)
+		return
+	}
 
+	auto := newAutoContinue(openAIReq, modelInfo)
+	var renderer *sectionRenderer
+	defer func() {
+		if session.completed && renderer != nil {
+			done.finish(c, openAIReq.Model, renderer.output.String(), renderer.finishReason())
+		}
+	}()
 	c.Stream(func(w io.Writer) bool {
-		for attempt := 0; attempt < maxRetries; attempt++ {
-			requestBody, err := createRequestBody(c, &openAIReq, modelInfo)
+		// 输出中断或被上游截断后续写时的上一次渲染器
+		var interrupted *sectionRenderer
+		for {
+			req := openAIReq
+			if interrupted != nil {
+				if partial := visibleOutput(interrupted.output.String()); partial != "" {
+					req = continuationRequest(openAIReq, partial)
+				}
+			}
+			requestBody, err := createRequestBody(c, &req, modelInfo)
 			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				session.fail(model.NewAPIError(http.StatusBadRequest, "invalid_request", err.Error()))
 				return false
 			}
 
 			jsonData, err := json.Marshal(requestBody)
 			if err != nil {
-				c.JSON(500, gin.H{"error": "Failed to marshal request body"})
+				session.fail(translateError(err))
 				return false
 			}
-			sseChan, err := alexsidebar_api.MakeStreamChatRequest(c, client, jsonData, cookie)
+			// 每次尝试独立的上下文, 重试前关闭上一次的上游连接
+			attemptCtx, cancelAttempt := context.WithCancel(ctx)
+			sseChan, err := alexsidebar_api.MakeStreamChatRequest(attemptCtx, client, jsonData, retry.cookie, retry.proxy())
 			if err != nil {
-				logger.Errorf(ctx, "MakeStreamChatRequest err on attempt %d: %v", attempt+1, err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				logger.Errorf(ctx, "MakeStreamChatRequest err on attempt %d: %v", retry.attempt+1, err)
+				session.fail(model.NewAPIError(http.StatusBadGateway, "upstream_error", err.Error()))
+				cancelAttempt()
 				return false
 			}
 
-			isRateLimit := false
+			var failure string
+			renderer = newSectionRenderer(openAIReq)
+			if interrupted != nil {
+				renderer.continueFrom(interrupted)
+			}
+			heartbeat := newHeartbeat()
+			continued := false
 		SSELoop:
-			for response := range sseChan {
+			for {
+				var response cycletls.SSEResponse
+				select {
+				case <-heartbeat.C():
+					if err := sendHeartbeat(session, responseId, openAIReq.Model); err != nil {
+						logger.Warnf(ctx, "send heartbeat err: %v", err)
+						heartbeat.Stop()
+						cancelAttempt()
+						return false
+					}
+					heartbeat.Reset()
+					continue
+				case r, ok := <-sseChan:
+					if !ok {
+						break SSELoop
+					}
+					response = r
+				}
+				heartbeat.Reset()
+
 				data := response.Data
 				if data == "" {
 					continue
 				}
 
 				if response.Done && data != "[DONE]" {
-					switch {
-					case common.IsUsageLimitExceeded(data):
-						isRateLimit = true
-						logger.Warnf(ctx, "Cookie Usage limit exceeded, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-						config.RemoveCookie(cookie)
-						break SSELoop
-					case common.IsChineseChat(data):
-						logger.Errorf(ctx, data)
-						c.JSON(http.StatusInternalServerError, gin.H{"error": "Detected that you are using Chinese for conversation, please use English for conversation."})
-						return false
-					case common.IsNotLogin(data):
-						isRateLimit = true
-						logger.Warnf(ctx, "Cookie Not Login, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-						break SSELoop // 使用 label 跳出 SSE 循环
-					case common.IsRateLimit(data):
-						isRateLimit = true
-						logger.Warnf(ctx, "Cookie rate limited, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-						config.AddRateLimitCookie(cookie, time.Now().Add(time.Duration(config.RateLimitCookieLockDuration)*time.Second))
-						break SSELoop
-					}
-					logger.Warnf(ctx, response.Data)
-					return false
+					failure = classifyUpstreamError(response)
+					logger.Warnf(ctx, "Upstream error %s on attempt %d, COOKIE:%s, data: %s", failure, retry.attempt+1, retry.cookie, data)
+					break SSELoop
 				}
 
 				logger.Debug(ctx, strings.TrimSpace(data))
 
-				_, shouldContinue := processStreamData(c, data, responseId, openAIReq.Model, jsonData, thinkStartType, thinkEndType, &lastText, &lastCode)
+				if data == "[DONE]" && auto.next(renderer, jsonData, openAIReq.Model) {
+					logger.Warnf(ctx, "Upstream output cut off, auto continuing round %d", auto.rounds)
+					continued = true
+					break SSELoop
+				}
+
+				_, shouldContinue := processStreamData(session, data, responseId, openAIReq.Model, jsonData, renderer, auto)
 				// 处理事件流数据
 
 				if !shouldContinue {
+					cancelAttempt()
 					return false
 				}
 			}
-
-			if !isRateLimit {
-				return true
-			}
-
-			// 获取下一个可用的cookie继续尝试
-			cookie, err = cookieManager.GetNextCookie()
-			if err != nil {
-				logger.Errorf(ctx, "No more valid cookies available after attempt %d", attempt+1)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return false
-			}
-		}
-
-		logger.Errorf(ctx, "All cookies exhausted after %d attempts", maxRetries)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "All cookies are temporarily unavailable."})
-		return false
-	})
-}
-
-func processStreamData(c *gin.Context, data, responseId, model string, jsonData []byte, thinkStartType, thinkEndType *bool, lastText, lastCode *string) (string, bool) {
-	data = strings.TrimSpace(data)
-	data = strings.TrimPrefix(data, "data: ")
-	if data == "[DONE]" {
-		handleMessageResult(c, responseId, model, jsonData)
-		return "", false
-	}
-
-	var event map[string]interface{}
-	if err := json.Unmarshal([]byte(data), &event); err != nil {
-		logger.Errorf(c.Request.Context(), "Failed to unmarshal event: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return "", false
-	}
-
-	sections, ok := event["sections"].([]interface{})
-	if !ok || len(sections) == 0 {
-		return "", true
-	}
-
-	section := sections[len(sections)-1].(map[string]interface{})
-
-	// 检查是否存在thinking状态
-	if textObj, ok := section["text"].(map[string]interface{}); ok && textObj != nil {
-		isThinking, _ := textObj["is_thinking"].(bool)
-
-		// 处理thinking开始和结束
-		if isThinking && !*thinkStartType {
-			// 第一次检测到thinking开始
-			*thinkStartType = true
-			if err := handleDelta(c, "<think>", responseId, model, jsonData); err != nil {
-				logger.Errorf(c.Request.Context(), "handleDelta err: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return "", false
-			}
-		} else if !isThinking && *thinkStartType && !*thinkEndType {
-			// thinking结束
-			*thinkEndType = true
-			if err := handleDelta(c, "</think>", responseId, model, jsonData); err != nil {
-				logger.Errorf(c.Request.Context(), "handleDelta err: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return "", false
+			cancelAttempt()
+			if continued {
+				heartbeat.Stop()
+				interrupted = renderer
+				continue
 			}
-		}
-	}
-
-	if *lastCode != "" && section["text"] != nil && section["code"] == nil {
-		if err := handleDelta(c, "\n\n", responseId, model, jsonData); err != nil {
-			logger.Errorf(c.Request.Context(), "handleDelta err: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return "", false
-		}
-		*lastCode = "" // 清空最后的代码
-	}
-
-	var textDelta string
-	if textObj, ok := section["text"].(map[string]interface{}); ok && textObj != nil {
-		if text, ok := textObj["text"].(string); ok && text != "" {
-			textDelta = getTextDelta(c, text, lastText)
 
-			if textDelta != "" {
-				if err := handleDelta(c, textDelta, responseId, model, jsonData); err != nil {
-					logger.Errorf(c.Request.Context(), "handleDelta err: %v", err)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-					return "", false
+			if failure == "" {
+				// 客户端已断开(合并请求时为全部客户端)
+				if ctx.Err() != nil {
+					return false
 				}
-
-				text = strings.TrimSuffix(text, "</new_")
-				text = strings.TrimSuffix(text, "</new")
-
-				*lastText = text // 更新最后的文本
-				return textDelta, true
+				// 上游未发送结束帧即断开, 视为网络错误
+				failure = config.ErrorClassNetwork
+				logger.Warnf(ctx, "Upstream stream closed without [DONE] on attempt %d", retry.attempt+1)
 			}
-		}
-	}
 
-	if codeObj, ok := section["code"].(map[string]interface{}); ok && codeObj != nil {
-		if code, ok := codeObj["code"].(string); ok {
-			codeDelta, isFirstCode := getCodeDelta(code, lastCode)
-
-			if codeDelta != "" {
-				if isFirstCode {
-					codeDelta = "\n\n" + codeDelta
-				}
+			heartbeat.Stop()
 
-				if err := handleDelta(c, codeDelta, responseId, model, jsonData); err != nil {
-					logger.Errorf(c.Request.Context(), "handleDelta err: %v", err)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-					return "", false
+			// 已向客户端输出内容时只能续写, 未开启续写时以错误事件结束流
+			if session.contentSent {
+				if !config.ContinuationFailoverEnabled {
+					logger.Errorf(ctx, "Upstream error %s after streaming started", failure)
+					session.fail(upstreamAPIError(failure))
+					return false
 				}
-				*lastCode = code
-			} else if *lastCode != "" && code == "" {
-				if err := handleDelta(c, "\n\n", responseId, model, jsonData); err != nil {
-					logger.Errorf(c.Request.Context(), "handleDelta err: %v", err)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-					return "", false
+				if err := retry.failover(failure); err != nil {
+					logger.Errorf(ctx, "Giving up continuation after attempt %d: %v", retry.attempt, err)
+					session.fail(translateError(err))
+					return false
 				}
-				*lastCode = ""
+				logger.Warnf(ctx, "Upstream error %s after streaming started, continuing on attempt %d", failure, retry.attempt+1)
+				interrupted = renderer
+				continue
 			}
 
-			return codeDelta, true
+			// 按重试策略切换账号/代理或放弃
+			if err := retry.next(failure); err != nil {
+				logger.Errorf(ctx, "Giving up after attempt %d: %v", retry.attempt, err)
+				session.fail(translateError(err))
+				return false
+			}
 		}
-	}
-
-	return "", true
+	})
 }
 
-// 获取文本增量
-func getTextDelta(c *gin.Context, currentText string, lastText *string) string {
-	if *lastText == "" {
-		return currentText
+func processStreamData(session *streamSession, data, responseId, modelName string, jsonData []byte, renderer *sectionRenderer, auto *autoContinue) (string, bool) {
+	ctx := session.c.Request.Context()
+	event, err := alexsidebar_api.DecodeEvent(data)
+	if err != nil {
+		logger.Errorf(ctx, "Failed to unmarshal event: %v", err)
+		session.fail(model.NewAPIError(http.StatusBadGateway, "invalid_upstream_response", err.Error()))
+		return "", false
 	}
 
-	currentText = strings.TrimSuffix(currentText, "</new_")
-	currentText = strings.TrimSuffix(currentText, "</new")
-
-	if strings.HasPrefix(strings.TrimSpace(currentText), strings.TrimSpace(*lastText)) {
-		// 返回增量部分
-		if len(*lastText) > len(currentText) {
-			currentText = strings.TrimSpace(currentText)
-			*lastText = strings.TrimSpace(*lastText)
+	var delta string
+	if event.Done {
+		delta = renderer.finish()
+	} else {
+		delta = renderer.render(event)
+	}
+	if delta != "" {
+		if err := handleDelta(session, delta, responseId, modelName, jsonData); err != nil {
+			logger.Errorf(ctx, "handleDelta err: %v", err)
+			session.fail(translateError(err))
+			return "", false
 		}
-		return currentText[len(*lastText):]
 	}
-
-	logger.Debug(c.Request.Context(), fmt.Sprintf("lastText: %s, currentText: %s", *lastText, currentText))
-
-	return "\n" + currentText
-}
-
-// 获取代码增量并判断是否是第一次有代码
-func getCodeDelta(currentCode string, lastCode *string) (string, bool) {
-	isFirstCode := *lastCode == "" && currentCode != ""
-
-	// 如果没有上一次的代码，返回整个当前代码
-	if *lastCode == "" {
-		return currentCode, isFirstCode
+	if toolCalls := renderer.takeToolCalls(); len(toolCalls) > 0 {
+		if err := session.sendContent(createStreamResponse(responseId, modelName, jsonData, model.OpenAIDelta{Role: "assistant", ToolCalls: toolCalls}, nil)); err != nil {
+			logger.Errorf(ctx, "send tool calls err: %v", err)
+			return "", false
+		}
 	}
-
-	// 检查当前代码是否以上一次的代码开头
-	if strings.HasPrefix(currentCode, *lastCode) {
-		// 返回增量部分
-		return currentCode[len(*lastCode):], isFirstCode
+	if event.Done {
+		handleMessageResult(session, responseId, modelName, jsonData, renderer, auto.usage(renderer, jsonData, modelName))
+		return delta, false
 	}
-
-	// 如果当前代码与上一次的代码不连续，返回整个当前代码
-	return currentCode, isFirstCode
+	return delta, true
 }
 
-func processNoStreamData(c *gin.Context, data string, thinkStartType *bool, thinkEndType *bool, lastText, lastCode *string) (string, bool) {
-	data = strings.TrimSpace(data)
-	data = strings.TrimPrefix(data, "data: ")
-	if data == "[DONE]" {
-		return "", false
-	}
-
-	var event map[string]interface{}
-	if err := json.Unmarshal([]byte(data), &event); err != nil {
+// processNoStreamData 渲染一帧上游数据, 返回本帧的增量及是否继续读取, 帧无法解析时返回错误
+func processNoStreamData(c *gin.Context, data string, renderer *sectionRenderer) (string, bool, error) {
+	event, err := alexsidebar_api.DecodeEvent(data)
+	if err != nil {
 		logger.Errorf(c.Request.Context(), "Failed to unmarshal event: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return "", false
+		return "", false, err
 	}
-
-	sections, ok := event["sections"].([]interface{})
-	if !ok || len(sections) == 0 {
-		return "", true
+	if event.Done {
+		return renderer.finish(), false, nil
 	}
-
-	section := sections[len(sections)-1].(map[string]interface{})
-
-	if *lastCode != "" && section["text"] != nil && section["code"] == nil {
-		*lastCode = "" // 清空最后的代码
-	}
-
-	var textDelta string
-	if textObj, ok := section["text"].(map[string]interface{}); ok && textObj != nil {
-		if text, ok := textObj["text"].(string); ok && text != "" {
-			textDelta = getTextDelta(nil, text, lastText)
-
-			if textDelta != "" {
-
-				text = strings.TrimSuffix(text, "</new_")
-				text = strings.TrimSuffix(text, "</new")
-
-				*lastText = text // 更新最后的文本
-				// 检查是否存在thinking状态
-				if textObj, ok := section["text"].(map[string]interface{}); ok && textObj != nil {
-					isThinking, _ := textObj["is_thinking"].(bool)
-
-					// 处理thinking开始和结束
-					if isThinking && !*thinkStartType {
-						// 第一次检测到thinking开始
-						*thinkStartType = true
-						textDelta = "<think>\n\n" + textDelta
-					} else if !isThinking && *thinkStartType && !*thinkEndType {
-						// thinking结束
-						*thinkEndType = true
-						textDelta = textDelta + "\n\n</think>\n\n"
-					}
-				}
-
-				return textDelta, true
-			}
-		}
-	}
-
-	if codeObj, ok := section["code"].(map[string]interface{}); ok && codeObj != nil {
-		if code, ok := codeObj["code"].(string); ok {
-			codeDelta, isFirstCode := getCodeDelta(code, lastCode)
-
-			if codeDelta != "" {
-				if isFirstCode {
-					codeDelta = "\n\n" + codeDelta
-				}
-
-				*lastCode = code
-			} else if *lastCode != "" && code == "" {
-
-				*lastCode = ""
-			}
-
-			return codeDelta, true
-		}
-	}
-
-	return "", true
-
+	return renderer.render(event), true, nil
 }
 
 // OpenaiModels @Summary OpenAI模型列表接口
@@ -728,7 +699,10 @@ func processNoStreamData(c *gin.Context, data string, thinkStartType *bool, thin
 func OpenaiModels(c *gin.Context) {
 	var modelsResp []string
 
-	modelsResp = lo.Union(common.GetModelList())
+	apiKey := getApiKey(c)
+	modelsResp = lo.Filter(lo.Union(common.GetModelList()), func(m string, _ int) bool {
+		return apiKey.AllowModel(m)
+	})
 
 	var openaiModelListResponse model.OpenaiModelListResponse
 	var openaiModelResponse []model.OpenaiModelResponse
@@ -745,13 +719,14 @@ func OpenaiModels(c *gin.Context) {
 	return
 }
 
-func safeClose(client cycletls.CycleTLS) {
-	if client.ReqChan != nil {
-		close(client.ReqChan)
-	}
-	if client.RespChan != nil {
-		close(client.RespChan)
+// getApiKey 获取鉴权中间件写入的密钥信息
+func getApiKey(c *gin.Context) config.ApiKey {
+	if value, ok := c.Get(helper.ApiKeyContextKey); ok {
+		if apiKey, ok := value.(config.ApiKey); ok {
+			return apiKey
+		}
 	}
+	return config.ApiKey{}
 }
 
 //
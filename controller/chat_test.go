@@ -0,0 +1,191 @@
+package controller
+
+import (
+	alexsidebar_api "alexsidebar2api/alexsidebar-api"
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/cycletls"
+	"alexsidebar2api/model"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChatWithFakeClient(t *testing.T) {
+	fake := &alexsidebar_api.FakeClient{
+		Frames: []string{
+			`{"sections":[{"text":{"text":"Hello","is_thinking":false}}]}`,
+			`{"sections":[{"text":{"text":"Hello from fake","is_thinking":false}}]}`,
+		},
+		Record: true,
+	}
+	router := newTestRouter(fake)
+
+	for _, stream := range []bool{false, true} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"model":  "claude-3-7-sonnet",
+			"stream": stream,
+			"messages": []map[string]string{
+				{"role": "system", "content": "Be brief."},
+				{"role": "user", "content": "hi"},
+			},
+		})
+		rec := doChat(router, string(body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("stream=%v: status %d: %s", stream, rec.Code, rec.Body.String())
+		}
+		got := parseChatResult(t, rec)
+		if got.Content != "Hello from fake" || got.FinishReason != "stop" {
+			t.Errorf("stream=%v: got %+v", stream, got)
+		}
+	}
+
+	if len(fake.Requests) != 2 {
+		t.Fatalf("expected 2 upstream requests, got %d", len(fake.Requests))
+	}
+	var upstream struct {
+		Model    string `json:"model"`
+		Prompt   string `json:"prompt"`
+		Messages []struct {
+			Role string `json:"role"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(fake.Requests[0].Body), &upstream); err != nil {
+		t.Fatal(err)
+	}
+	if upstream.Model != "agent_sonnet_37" || upstream.Prompt != "Be brief." || len(upstream.Messages) != 1 || upstream.Messages[0].Role != "User" {
+		t.Errorf("unexpected upstream request: %+v", upstream)
+	}
+	if auth := fake.Requests[0].Headers["auth"]; auth != "test-token" {
+		t.Errorf("expected account token in auth header, got %q", auth)
+	}
+}
+
+func TestChatValidation(t *testing.T) {
+	router := newTestRouter(&alexsidebar_api.FakeClient{})
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"invalid json", `{`, http.StatusBadRequest},
+		{"unknown model", `{"model":"no-such-model","messages":[{"role":"user","content":"hi"}]}`, http.StatusNotFound},
+		{"max tokens", `{"model":"claude-3-7-sonnet","max_tokens":100000000,"messages":[{"role":"user","content":"hi"}]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := doChat(router, tt.body); rec.Code != tt.code {
+				t.Errorf("expected %d, got %d: %s", tt.code, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestChatSectionRewrite(t *testing.T) {
+	router := newTestRouter(&alexsidebar_api.FakeClient{
+		Frames: []string{
+			`{"sections":[{"text":{"text":"Hello wrld"}}]}`,
+			`{"sections":[{"text":{"text":"Hello world"}}]}`,
+			`{"sections":[{"text":{"text":"Hello world, again"}}]}`,
+		},
+	})
+	rec := doChat(router, `{"model":"claude-3-7-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	// 已输出的内容无法撤回, 另起一行输出改写后的完整内容
+	if got := parseChatResult(t, rec).Content; got != "Hello wrld\nHello world, again" {
+		t.Errorf("got %q", got)
+	}
+}
+
+// TestStreamErrorBeforeFirstFrame 角色立即发送, 首个上游帧之前失败时以流内错误事件结束
+func TestStreamErrorBeforeFirstFrame(t *testing.T) {
+	defer func(attempts int) { config.RetryMaxAttempts = attempts }(config.RetryMaxAttempts)
+	config.RetryMaxAttempts = 1
+
+	router := newTestRouter(&alexsidebar_api.FakeClient{Status: http.StatusInternalServerError, Body: "Internal Server Error"})
+	rec := doChat(router, `{"model":"claude-3-7-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("expected an event stream, got %d %s: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	first, _, _ := strings.Cut(rec.Body.String(), "\n\n")
+	var chunk model.OpenAIChatCompletionResponse
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(first, "data: ")), &chunk); err != nil || len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Role != "assistant" {
+		t.Errorf("expected the role chunk first, got %q", first)
+	}
+	if got := parseChatResult(t, rec); got.Error == "" {
+		t.Errorf("expected error event, got %s", rec.Body.String())
+	}
+}
+
+// TestStreamUsage 流式响应的最后一个 chunk 携带用量
+func TestStreamUsage(t *testing.T) {
+	router := newTestRouter(&alexsidebar_api.FakeClient{
+		Frames: []string{`{"sections":[{"text":{"text":"Hello from fake"}}]}`},
+	})
+	rec := doChat(router, `{"model":"claude-3-7-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+
+	var last model.OpenAIChatCompletionResponse
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(data), &last); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(last.Choices) == 0 || last.Choices[0].FinishReason == nil {
+		t.Fatalf("last chunk has no finish_reason: %s", rec.Body.String())
+	}
+	usage := last.Usage
+	if usage.PromptTokens == 0 || usage.CompletionTokens == 0 || usage.TotalTokens != usage.PromptTokens+usage.CompletionTokens {
+		t.Errorf("unexpected usage on final chunk: %+v", usage)
+	}
+}
+
+// truncatedClient 上游输出一帧后未发送结束帧即断开
+type truncatedClient struct{}
+
+func (truncatedClient) DoSSE(ctx context.Context, URL string, options cycletls.Options, Method string) (<-chan cycletls.SSEResponse, error) {
+	sseChan := make(chan cycletls.SSEResponse, 1)
+	sseChan <- cycletls.SSEResponse{Status: http.StatusOK, Data: `{"sections":[{"text":{"text":"Hel"}}]}`}
+	close(sseChan)
+	return sseChan, nil
+}
+
+// TestNonStreamClosedWithoutDone 非流式请求上游未发送结束帧即断开时按网络错误重试, 耗尽后返回错误
+func TestNonStreamClosedWithoutDone(t *testing.T) {
+	defer func(attempts int) { config.RetryMaxAttempts = attempts }(config.RetryMaxAttempts)
+	config.RetryMaxAttempts = 1
+
+	router := newTestRouter(truncatedClient{})
+	rec := doChat(router, `{"model":"claude-3-7-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code == http.StatusOK {
+		t.Fatalf("expected an error status, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := parseChatResult(t, rec); got.Error == "" {
+		t.Errorf("expected error body, got %q", rec.Body.String())
+	}
+}
+
+// TestNonStreamMalformedFrame 上游帧无法解析时返回 502, 不写入成功响应也不缓存
+func TestNonStreamMalformedFrame(t *testing.T) {
+	defer func(enabled bool) { config.ResponseCacheEnabled = enabled }(config.ResponseCacheEnabled)
+	config.ResponseCacheEnabled = true
+	defer model.PurgeResponseCache()
+
+	router := newTestRouter(&alexsidebar_api.FakeClient{
+		Frames: []string{`{"sections":[{"text":{"text":"Hel"}}]}`, `{"sections":[`},
+	})
+	rec := doChat(router, `{"model":"claude-3-7-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body model.OpenAIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.OpenAIError.Code != "invalid_upstream_response" {
+		t.Errorf("expected a single error body, got %q", rec.Body.String())
+	}
+	if stats, _ := model.GetResponseCacheStats(); stats.Entries != 0 {
+		t.Errorf("truncated reply was cached: %+v", stats)
+	}
+}
@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"alexsidebar2api/common/config"
+	logger "alexsidebar2api/common/loggger"
+	"alexsidebar2api/model"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// flight 进行中的上游请求, 相同的并发请求共享其输出
+// 上游请求使用独立于发起者客户端的上下文, 发起者及全部跟随者都离开后才取消
+type flight struct {
+	key          string
+	ctx          context.Context
+	cancel       context.CancelFunc
+	subscribers  int         // 尚未离开的发起者及跟随者数, 由 flightsMutex 保护
+	stopLeader   func() bool // 取消监听发起者客户端断开
+	mu           sync.Mutex
+	chunks       []string
+	finished     bool
+	failed       bool
+	finishReason string
+	updated      chan struct{} // 每次更新时关闭并替换, 用于唤醒跟随者
+}
+
+var (
+	flights      = map[string]*flight{}
+	flightsMutex sync.Mutex
+)
+
+// joinFlight 加入相同请求的 flight, 不存在时创建并作为发起者
+// 未开启合并或请求的回复含结构化内容时返回 nil, 只合并同一密钥及账号分组的请求
+// 跟随者结束后需调用 unsubscribe, 发起者在客户端断开时自动离开
+func joinFlight(c *gin.Context, openAIReq model.OpenAIChatCompletionRequest, apiKey config.ApiKey) (*flight, bool) {
+	if !config.CoalesceEnabled || len(openAIReq.Tools) > 0 || openAIReq.CodeBlocks {
+		return nil, false
+	}
+	key := responseCacheKey(openAIReq, model.ConversationOwner(apiKey.Key)) + ":" + apiKey.AccountGroup
+
+	flightsMutex.Lock()
+	defer flightsMutex.Unlock()
+	if f, ok := flights[key]; ok {
+		f.subscribers++
+		return f, false
+	}
+	// 保留请求上下文中的值(如日志的请求 id), 不随发起者客户端断开而取消
+	ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+	f := &flight{key: key, ctx: ctx, cancel: cancel, subscribers: 1, updated: make(chan struct{})}
+	f.stopLeader = context.AfterFunc(c.Request.Context(), f.unsubscribe)
+	flights[key] = f
+	return f, true
+}
+
+// upstreamContext 上游请求使用的上下文, 发起者使用 flight 的上下文, 其余请求使用客户端请求的上下文
+func (f *flight) upstreamContext(c *gin.Context) context.Context {
+	if f == nil {
+		return c.Request.Context()
+	}
+	return f.ctx
+}
+
+// unsubscribe 发起者或跟随者离开, 最后一个离开时取消上游请求
+func (f *flight) unsubscribe() {
+	if f == nil {
+		return
+	}
+	flightsMutex.Lock()
+	defer flightsMutex.Unlock()
+	if f.subscribers--; f.subscribers == 0 {
+		f.cancel()
+	}
+}
+
+// detached 发起者的客户端已断开, 上游请求仍为跟随者继续
+func (f *flight) detached(c *gin.Context) bool {
+	return f != nil && c.Request.Context().Err() != nil
+}
+
+// notify 唤醒等待中的跟随者, 调用方需持有锁
+func (f *flight) notify() {
+	close(f.updated)
+	f.updated = make(chan struct{})
+}
+
+// publish 转发发起者已输出的内容
+func (f *flight) publish(delta string) {
+	if f == nil || delta == "" {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.chunks = append(f.chunks, delta)
+	f.notify()
+}
+
+// finish 发起者正常结束, 非流式请求此前没有转发过内容, 一次性转发全部输出
+func (f *flight) finish(output, finishReason string) {
+	if f == nil {
+		return
+	}
+	f.leave()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.finished || f.failed {
+		return
+	}
+	if len(f.chunks) == 0 && output != "" {
+		f.chunks = append(f.chunks, output)
+	}
+	f.finished = true
+	f.finishReason = finishReason
+	f.notify()
+}
+
+// close 发起者返回时调用, 未正常结束视为失败
+func (f *flight) close() {
+	if f == nil {
+		return
+	}
+	f.leave()
+	f.stopLeader()
+	f.cancel()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.finished || f.failed {
+		return
+	}
+	f.failed = true
+	f.notify()
+}
+
+// leave 结束后不再接受新的跟随者
+func (f *flight) leave() {
+	flightsMutex.Lock()
+	defer flightsMutex.Unlock()
+	if flights[f.key] == f {
+		delete(flights, f.key)
+	}
+}
+
+// read 返回第 from 块之后的内容、当前状态及下次更新的通知
+func (f *flight) read(from int) ([]string, bool, bool, <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.chunks[from:], f.finished, f.failed, f.updated
+}
+
+// followFlight 跟随者等待发起者的输出, 先返回已缓冲的内容再转发后续内容, 每个跟随者使用独立的响应 id
+// 发起者在输出任何内容前失败时返回 false, 由调用方独立请求上游
+func followFlight(c *gin.Context, openAIReq model.OpenAIChatCompletionRequest, f *flight) (string, bool) {
+	ctx := c.Request.Context()
+	c.Header("X-Coalesced", "true")
+	responseId := newResponseID()
+
+	var session *streamSession
+	var beat *heartbeat
+	if openAIReq.Stream {
+		session = newStreamSession(c)
+		beat = newHeartbeat()
+		defer beat.Stop()
+	}
+
+	var output strings.Builder
+	next := 0
+	for {
+		chunks, finished, failed, updated := f.read(next)
+		next += len(chunks)
+		for _, chunk := range chunks {
+			output.WriteString(chunk)
+			if session == nil {
+				continue
+			}
+			if err := session.sendContent(replayChunk(responseId, openAIReq.Model, model.OpenAIDelta{Role: "assistant", Content: chunk}, nil)); err != nil {
+				return "", true
+			}
+			beat.Reset()
+		}
+
+		switch {
+		case failed && next == 0 && (session == nil || !session.headersFlushed):
+			logger.Warnf(ctx, "Coalesced request failed before any output, requesting upstream independently")
+			c.Writer.Header().Del("X-Coalesced")
+			return "", false
+		case failed:
+			err := model.NewAPIError(http.StatusBadGateway, "upstream_error", "Coalesced upstream request failed")
+			if session != nil {
+				session.fail(err)
+			} else {
+				sendError(c, err)
+			}
+			return "", true
+		case finished:
+			replayFinish(c, session, responseId, openAIReq, output.String(), f.finishReason)
+			return output.String(), true
+		}
+
+		var tick <-chan time.Time
+		if beat != nil {
+			tick = beat.C()
+		}
+		select {
+		case <-updated:
+		case <-tick:
+			if err := sendHeartbeat(session, responseId, openAIReq.Model); err != nil {
+				return "", true
+			}
+			beat.Reset()
+		case <-ctx.Done():
+			return "", true
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/cycletls"
+	"alexsidebar2api/model"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pipeClient 上游逐帧输出由测试控制
+type pipeClient struct {
+	mu      sync.Mutex
+	calls   int
+	ctx     context.Context
+	writer  *io.PipeWriter
+	started chan struct{}
+}
+
+func newPipeClient() *pipeClient {
+	return &pipeClient{started: make(chan struct{})}
+}
+
+func (p *pipeClient) DoSSE(ctx context.Context, URL string, options cycletls.Options, Method string) (<-chan cycletls.SSEResponse, error) {
+	reader, writer := io.Pipe()
+	context.AfterFunc(ctx, func() { writer.CloseWithError(ctx.Err()) })
+	p.mu.Lock()
+	p.calls++
+	p.ctx, p.writer = ctx, writer
+	if p.calls == 1 {
+		close(p.started)
+	}
+	p.mu.Unlock()
+
+	sseChan := make(chan cycletls.SSEResponse)
+	go func() {
+		defer close(sseChan)
+		cycletls.ReadSSE(ctx, reader, http.StatusOK, "", URL, sseChan)
+	}()
+	return sseChan, nil
+}
+
+func (p *pipeClient) frame(t *testing.T, text string) {
+	t.Helper()
+	p.mu.Lock()
+	writer := p.writer
+	p.mu.Unlock()
+	if _, err := io.WriteString(writer, `{"sections":[{"text":{"text":"`+text+`"}}]}›`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startChat 在后台发送请求, ctx 取消相当于客户端断开
+func startChat(ctx context.Context, router http.Handler, body string) <-chan *httptest.ResponseRecorder {
+	result := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		rec := &closeNotifyingRecorder{ResponseRecorder: httptest.NewRecorder(), closed: make(chan bool)}
+		router.ServeHTTP(rec, req)
+		result <- rec.ResponseRecorder
+	}()
+	return result
+}
+
+// waitSubscribers 等待进行中的 flight 达到指定的订阅数
+func waitSubscribers(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		flightsMutex.Lock()
+		count := -1
+		for _, f := range flights {
+			count = f.subscribers
+		}
+		flightsMutex.Unlock()
+		if count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d subscribers, got %d", n, count)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func enableCoalesce(t *testing.T) {
+	t.Helper()
+	enabled := config.CoalesceEnabled
+	t.Cleanup(func() { config.CoalesceEnabled = enabled })
+	config.CoalesceEnabled = true
+}
+
+// TestCoalesceLeaderDisconnect 发起者客户端断开后上游请求继续, 跟随者收到完整输出
+func TestCoalesceLeaderDisconnect(t *testing.T) {
+	enableCoalesce(t)
+	client := newPipeClient()
+	router := newTestRouter(client)
+
+	leaderCtx, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+	leader := startChat(leaderCtx, router, `{"model":"claude-3-7-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	<-client.started
+	client.frame(t, "Hello")
+
+	follower := startChat(context.Background(), router, `{"model":"claude-3-7-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+	waitSubscribers(t, 2)
+
+	disconnect()
+	waitSubscribers(t, 1)
+	if err := client.ctx.Err(); err != nil {
+		t.Fatalf("upstream cancelled after leader disconnect: %v", err)
+	}
+	client.frame(t, "Hello world")
+	client.writer.Close()
+
+	got := parseChatResult(t, <-follower)
+	if got.Content != "Hello world" || got.FinishReason != "stop" {
+		t.Errorf("unexpected follower result %+v", got)
+	}
+	<-leader
+	if client.calls != 1 {
+		t.Errorf("expected 1 upstream request, got %d", client.calls)
+	}
+}
+
+// TestCoalesceAllClientsLeave 发起者及跟随者都断开后取消上游请求
+func TestCoalesceAllClientsLeave(t *testing.T) {
+	enableCoalesce(t)
+	client := newPipeClient()
+	router := newTestRouter(client)
+
+	leaderCtx, disconnectLeader := context.WithCancel(context.Background())
+	followerCtx, disconnectFollower := context.WithCancel(context.Background())
+	leader := startChat(leaderCtx, router, `{"model":"claude-3-7-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	<-client.started
+	client.frame(t, "Hello")
+	follower := startChat(followerCtx, router, `{"model":"claude-3-7-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	waitSubscribers(t, 2)
+
+	disconnectLeader()
+	waitSubscribers(t, 1)
+	disconnectFollower()
+	<-follower
+	<-leader
+	select {
+	case <-client.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream not cancelled after all clients left")
+	}
+}
+
+// TestCoalesceKeyAccountGroup 不同账号分组的相同请求不合并
+func TestCoalesceKeyAccountGroup(t *testing.T) {
+	enableCoalesce(t)
+	req := model.OpenAIChatCompletionRequest{
+		Model:    "claude-3-7-sonnet",
+		Messages: []model.OpenAIChatMessage{{Role: "user", Content: "hi"}},
+	}
+
+	var joined []*flight
+	for _, group := range []string{"team-a", "team-b", "team-a"} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		f, _ := joinFlight(c, req, config.ApiKey{Key: "sk-test", AccountGroup: group})
+		joined = append(joined, f)
+	}
+	defer joined[0].close()
+	defer joined[1].close()
+	if joined[0] == joined[1] {
+		t.Error("requests of different account groups were coalesced")
+	}
+	if joined[0] != joined[2] {
+		t.Error("requests of the same account group were not coalesced")
+	}
+}
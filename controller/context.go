@@ -0,0 +1,235 @@
+package controller
+
+import (
+	alexsidebar_api "alexsidebar2api/alexsidebar-api"
+	"alexsidebar2api/common"
+	"alexsidebar2api/common/config"
+	logger "alexsidebar2api/common/loggger"
+	"alexsidebar2api/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 上下文管理策略
+const (
+	contextStrategyNone       = "none"
+	contextStrategyDropOldest = "drop_oldest"
+	contextStrategyKeepLast   = "keep_last"
+	contextStrategySummarize  = "summarize"
+)
+
+const contextSummaryPrompt = "Summarize the following earlier part of a conversation between a user and an assistant. " +
+	"Keep every fact, decision, requirement, file name and code identifier that later turns may rely on. " +
+	"Reply with the summary only."
+
+// contextReport 上下文管理结果, 通过 X-Context-Management 响应头返回
+type contextReport struct {
+	strategy   string
+	before     int
+	after      int
+	dropped    int // 丢弃的消息数
+	summarized int // 被摘要替换的消息数
+}
+
+func (r contextReport) String() string {
+	report := fmt.Sprintf("%s; tokens=%d->%d", r.strategy, r.before, r.after)
+	if r.dropped > 0 {
+		report += fmt.Sprintf("; dropped=%d", r.dropped)
+	}
+	if r.summarized > 0 {
+		report += fmt.Sprintf("; summarized=%d", r.summarized)
+	}
+	return report
+}
+
+// contextBudget 提示词可用的 tokens, 需为输出预留 max_tokens
+// 上游单次回复不超过 defaultMaxTokens (更长的输出由续写完成), 预留也不超过上限的一半
+func contextBudget(openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) int {
+	limit := modelInfo.MaxTokens
+	if config.ContextMaxTokens > 0 {
+		limit = config.ContextMaxTokens
+	}
+	reserve := openAIReq.MaxTokens
+	if reserve <= 1 {
+		reserve = defaultMaxTokens
+	}
+	return limit - min(reserve, defaultMaxTokens, limit/2)
+}
+
+// splitTurns 拆分开头的 system/developer 消息及紧随其后的 pinned 条预置消息与之后的各轮对话, 每轮以 user 消息开始
+func splitTurns(messages []model.OpenAIChatMessage, pinned int) ([]model.OpenAIChatMessage, [][]model.OpenAIChatMessage) {
+	i := 0
+	for i < len(messages) && (messages[i].Role == "system" || messages[i].Role == "developer") {
+		i++
+	}
+	i = min(i+pinned, len(messages))
+	var turns [][]model.OpenAIChatMessage
+	for _, message := range messages[i:] {
+		if len(turns) == 0 || message.Role == "user" {
+			turns = append(turns, nil)
+		}
+		turns[len(turns)-1] = append(turns[len(turns)-1], message)
+	}
+	return messages[:i], turns
+}
+
+func joinTurns(system []model.OpenAIChatMessage, turns [][]model.OpenAIChatMessage) []model.OpenAIChatMessage {
+	messages := append([]model.OpenAIChatMessage{}, system...)
+	for _, turn := range turns {
+		messages = append(messages, turn...)
+	}
+	return messages
+}
+
+func countTurnMessages(turns [][]model.OpenAIChatMessage) int {
+	count := 0
+	for _, turn := range turns {
+		count += len(turn)
+	}
+	return count
+}
+
+// manageContext 提示词超过上限时按策略裁剪或摘要历史消息, 未超过上限时返回 nil
+func manageContext(c *gin.Context, client alexsidebar_api.Client, openAIReq *model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo, accountGroup string) (*contextReport, error) {
+	strategy := config.ContextStrategy
+	if strategy == contextStrategyNone || strategy == "" {
+		return nil, nil
+	}
+	budget := contextBudget(*openAIReq, modelInfo)
+	before := model.CountTokenMessages(openAIReq.Messages, openAIReq.Model)
+	if before <= budget {
+		return nil, nil
+	}
+
+	report := &contextReport{strategy: strategy, before: before}
+	system, turns := splitTurns(openAIReq.Messages, openAIReq.PinnedMessages)
+	keep := max(1, config.ContextKeepTurns)
+
+	switch strategy {
+	case contextStrategyKeepLast:
+		if len(turns) > keep {
+			report.dropped += countTurnMessages(turns[:len(turns)-keep])
+			turns = turns[len(turns)-keep:]
+		}
+	case contextStrategySummarize:
+		if len(turns) > keep {
+			old := turns[:len(turns)-keep]
+			summary, err := summarizeTurns(c, client, old, accountGroup)
+			if err != nil {
+				// 摘要失败时退回丢弃最早的对话
+				logger.Warnf(c.Request.Context(), "summarize context err: %v", err)
+				report.strategy = contextStrategyDropOldest
+				break
+			}
+			report.summarized = countTurnMessages(old)
+			system = append(append([]model.OpenAIChatMessage{}, system...), model.OpenAIChatMessage{
+				Role:    "system",
+				Content: "Summary of the earlier conversation:\n" + summary,
+			})
+			turns = turns[len(turns)-keep:]
+		}
+	}
+
+	// 仍超过上限时丢弃最早的对话, 至少保留最后一轮
+	for len(turns) > 1 && model.CountTokenMessages(joinTurns(system, turns), openAIReq.Model) > budget {
+		report.dropped += len(turns[0])
+		turns = turns[1:]
+	}
+
+	// 摘要本身过长时放弃摘要
+	if report.summarized > 0 && model.CountTokenMessages(joinTurns(system, turns), openAIReq.Model) > budget {
+		system = system[:len(system)-1]
+		report.dropped += report.summarized
+		report.summarized = 0
+		report.strategy = contextStrategyDropOldest
+	}
+
+	openAIReq.Messages = joinTurns(system, turns)
+	report.after = model.CountTokenMessages(openAIReq.Messages, openAIReq.Model)
+	if report.after > budget {
+		return report, model.NewAPIError(http.StatusBadRequest, "context_length_exceeded",
+			fmt.Sprintf("Prompt has %d tokens after context management, exceeding the limit of %d", report.after, budget))
+	}
+	logger.Infof(c.Request.Context(), "Context managed: %s", report)
+	return report, nil
+}
+
+// summarizeTurns 使用 CONTEXT_SUMMARY_MODEL 摘要较早的对话
+func summarizeTurns(c *gin.Context, client alexsidebar_api.Client, turns [][]model.OpenAIChatMessage, accountGroup string) (string, error) {
+	modelInfo, ok := common.GetModelInfo(config.ContextSummaryModel)
+	if !ok {
+		return "", fmt.Errorf("summary model %s not supported", config.ContextSummaryModel)
+	}
+
+	var transcript strings.Builder
+	for _, turn := range turns {
+		for _, message := range turn {
+			fmt.Fprintf(&transcript, "%s: %s\n\n", message.Role, message.TextContent())
+		}
+	}
+	// 摘要请求本身也需在摘要模型的上限之内, 超出时保留较新的部分
+	text := []rune(transcript.String())
+	budget := contextBudget(model.OpenAIChatCompletionRequest{}, modelInfo)
+	for len(text) > 0 && model.CountTokenText(string(text), config.ContextSummaryModel) > budget {
+		text = text[len(text)/4:]
+	}
+
+	req := model.OpenAIChatCompletionRequest{
+		Model: config.ContextSummaryModel,
+		Messages: []model.OpenAIChatMessage{
+			{Role: "system", Content: contextSummaryPrompt},
+			{Role: "user", Content: string(text)},
+		},
+	}
+	return completeOnce(c, client, req, modelInfo, accountGroup)
+}
+
+// completeOnce 发起一次不重试的非流式请求, 返回去掉思考过程的回复
+func completeOnce(c *gin.Context, client alexsidebar_api.Client, req model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo, accountGroup string) (string, error) {
+	ctx := c.Request.Context()
+	retry, err := newRetryState(ctx, accountGroup)
+	if err != nil {
+		return "", err
+	}
+	requestBody, err := createRequestBody(c, &req, modelInfo)
+	if err != nil {
+		return "", err
+	}
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", err
+	}
+
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sseChan, err := alexsidebar_api.MakeStreamChatRequest(attemptCtx, client, jsonData, retry.cookie, retry.proxy())
+	if err != nil {
+		return "", err
+	}
+
+	renderer := newSectionRenderer(req)
+	for response := range sseChan {
+		if response.Data == "" {
+			continue
+		}
+		if response.Done && response.Data != "[DONE]" {
+			return "", upstreamAPIError(classifyUpstreamError(response))
+		}
+		event, err := alexsidebar_api.DecodeEvent(response.Data)
+		if err != nil {
+			return "", err
+		}
+		if event.Done {
+			renderer.finish()
+			return strings.TrimSpace(visibleOutput(renderer.output.String())), nil
+		}
+		renderer.render(event)
+	}
+	return "", errors.New("upstream stream closed without [DONE]")
+}
@@ -0,0 +1,95 @@
+package controller
+
+import (
+	alexsidebar_api "alexsidebar2api/alexsidebar-api"
+	"alexsidebar2api/common"
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestContextBudget(t *testing.T) {
+	defer func(limit int) { config.ContextMaxTokens = limit }(config.ContextMaxTokens)
+	modelInfo, _ := common.GetModelInfo("claude-3-7-sonnet")
+
+	tests := []struct {
+		name      string
+		limit     int
+		maxTokens int
+		want      int
+	}{
+		{"default reserve", 0, 0, modelInfo.MaxTokens - defaultMaxTokens},
+		{"small max_tokens", 0, 1000, modelInfo.MaxTokens - 1000},
+		// 上游单次回复不超过 defaultMaxTokens, 不必预留更多
+		{"max_tokens equals model limit", 0, modelInfo.MaxTokens, modelInfo.MaxTokens - defaultMaxTokens},
+		{"reserve at most half of limit", 6000, 0, 3000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.ContextMaxTokens = tt.limit
+			req := model.OpenAIChatCompletionRequest{Model: "claude-3-7-sonnet", MaxTokens: tt.maxTokens}
+			if got := contextBudget(req, modelInfo); got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestManageContextLargeMaxTokens max_tokens 等于模型上限时短提示词不应被拒绝
+func TestManageContextLargeMaxTokens(t *testing.T) {
+	defer func(strategy string) { config.ContextStrategy = strategy }(config.ContextStrategy)
+	config.ContextStrategy = contextStrategyDropOldest
+
+	router := newTestRouter(&alexsidebar_api.FakeClient{
+		Frames: []string{`{"sections":[{"text":{"text":"Hello"}}]}`},
+	})
+	rec := doChat(router, `{"model":"claude-3-7-sonnet","max_tokens":100000,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestManageContextKeepsPresets drop_oldest 与 system 消息一样保留模板的预置消息
+func TestManageContextKeepsPresets(t *testing.T) {
+	defer func(strategy string, limit int) {
+		config.ContextStrategy, config.ContextMaxTokens = strategy, limit
+	}(config.ContextStrategy, config.ContextMaxTokens)
+	config.ContextStrategy = contextStrategyDropOldest
+	config.ContextMaxTokens = 400
+
+	req := model.OpenAIChatCompletionRequest{
+		Model: "claude-3-7-sonnet",
+		Messages: []model.OpenAIChatMessage{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "Preset question"},
+			{Role: "assistant", Content: "Preset answer"},
+			{Role: "user", Content: strings.Repeat("old ", 200)},
+			{Role: "assistant", Content: "old answer"},
+			{Role: "user", Content: "latest"},
+		},
+		PinnedMessages: 2,
+	}
+	modelInfo, _ := common.GetModelInfo(req.Model)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	report, err := manageContext(c, &alexsidebar_api.FakeClient{}, &req, modelInfo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report == nil || report.dropped != 2 {
+		t.Fatalf("expected the oldest turn to be dropped, got %+v", report)
+	}
+	var contents []string
+	for _, message := range req.Messages {
+		contents = append(contents, message.TextContent())
+	}
+	if got := strings.Join(contents, "|"); got != "Be brief.|Preset question|Preset answer|latest" {
+		t.Errorf("unexpected messages %q", got)
+	}
+}
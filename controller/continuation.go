@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"alexsidebar2api/common"
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/model"
+	"strings"
+	"unicode"
+)
+
+// minContinuationOverlap 续写开头与已输出内容的重叠达到该长度才视为重复, 避免误删巧合相同的短文本
+const minContinuationOverlap = 8
+
+// continuationRequest 将已输出内容作为助手消息, 并追加续写指令
+// 续写不再需要思考过程, 使用非 thinking 模型
+func continuationRequest(openAIReq model.OpenAIChatCompletionRequest, partial string) model.OpenAIChatCompletionRequest {
+	req := openAIReq
+	req.Model = strings.TrimSuffix(openAIReq.Model, "-thinking")
+	req.Messages = append(append([]model.OpenAIChatMessage{}, openAIReq.Messages...),
+		model.OpenAIChatMessage{Role: "assistant", Content: partial},
+		model.OpenAIChatMessage{Role: "user", Content: config.ContinuationPrompt},
+	)
+	return req
+}
+
+// visibleOutput 去掉思考过程后的已输出内容
+func visibleOutput(output string) string {
+	if !strings.HasPrefix(output, "<think>") {
+		return output
+	}
+	if _, after, ok := strings.Cut(output, "\n\n</think>\n\n"); ok {
+		return after
+	}
+	return ""
+}
+
+// continuationSplicer 去掉续写开头与已输出内容重复的部分
+// 续写可能从中断处之前的某个位置重新开始, 在能确定重叠长度之前暂缓输出
+type continuationSplicer struct {
+	prev    string
+	buf     string
+	decided bool
+}
+
+func newContinuationSplicer(prev string) *continuationSplicer {
+	return &continuationSplicer{prev: prev, decided: prev == ""}
+}
+
+// splice 返回可以输出的续写内容, final 为 true 时输出全部暂缓的内容
+func (s *continuationSplicer) splice(out string, final bool) string {
+	if s.decided {
+		return out
+	}
+	s.buf += out
+	body := strings.TrimLeftFunc(s.buf, unicode.IsSpace)
+	// 续写内容仍是已输出内容的一部分, 可能还在重复
+	if !final && (body == "" || strings.Contains(s.prev, body)) {
+		return ""
+	}
+
+	s.decided = true
+	buf := s.buf
+	s.buf = ""
+	for p := 0; p <= len(s.prev)-minContinuationOverlap; p++ {
+		if overlap := s.prev[p:]; strings.HasPrefix(body, overlap) {
+			return body[len(overlap):]
+		}
+	}
+	return buf
+}
+
+// autoContinue 上游因自身输出上限截断时自动续写, 直到模型自然结束或达到客户端的 max_tokens
+type autoContinue struct {
+	limit        int // 全部轮次的输出上限
+	roundLimit   int // 单轮请求的 max_tokens, 单轮输出达到该值视为被截断
+	rounds       int
+	promptTokens int // 之前各轮请求的 prompt tokens
+}
+
+// newAutoContinue 客户端未指定 max_tokens 时以模型上限为准
+func newAutoContinue(openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) *autoContinue {
+	limit := openAIReq.MaxTokens
+	if limit <= 1 {
+		limit = modelInfo.MaxTokens
+	}
+	// 与 createRequestBody 一致, 上游单次回复不超过 defaultMaxTokens
+	return &autoContinue{limit: limit, roundLimit: min(limit, defaultMaxTokens)}
+}
+
+// next 上游正常结束时判断是否需要续写
+// 仅当本轮输出达到单轮 max_tokens 或以未闭合的修改标记结束时视为被截断, 模型自然结束时不续写
+// 被截断但已达到上限不再续写时标记为截断
+func (a *autoContinue) next(r *sectionRenderer, jsonData []byte, modelName string) bool {
+	if !config.AutoContinueEnabled {
+		return false
+	}
+	if model.CountTokenText(r.roundOutput(), modelName) < a.roundLimit && !r.pendingEdit() {
+		return false
+	}
+	if a.rounds >= config.AutoContinueMaxRounds || model.CountTokenText(r.output.String(), modelName) >= a.limit {
+		r.truncated = true
+		return false
+	}
+	a.rounds++
+	a.promptTokens += model.CountTokenText(string(jsonData), modelName)
+	return true
+}
+
+// usage 各轮请求用量之和
+func (a *autoContinue) usage(r *sectionRenderer, jsonData []byte, modelName string) model.OpenAIUsage {
+	promptTokens := a.promptTokens + model.CountTokenText(string(jsonData), modelName)
+	completionTokens := model.CountTokenText(r.output.String(), modelName)
+	return model.OpenAIUsage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+}
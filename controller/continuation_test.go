@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"alexsidebar2api/common"
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/model"
+	"strings"
+	"testing"
+)
+
+func TestAutoContinueNext(t *testing.T) {
+	defer func(enabled bool, rounds int) {
+		config.AutoContinueEnabled, config.AutoContinueMaxRounds = enabled, rounds
+	}(config.AutoContinueEnabled, config.AutoContinueMaxRounds)
+	config.AutoContinueEnabled = true
+	config.AutoContinueMaxRounds = 1
+
+	modelInfo := common.ModelInfo{Model: "agent_sonnet_37", MaxTokens: 100000}
+	// 测试中未加载编码器, 按 4 字节一个 token 估算
+	long := strings.Repeat("word", defaultMaxTokens)
+	tests := []struct {
+		name      string
+		maxTokens int
+		rounds    int
+		output    string
+		continued bool
+		finish    string
+	}{
+		{name: "natural stop", output: strings.Repeat("word", defaultMaxTokens-500), finish: "stop"},
+		{name: "cut off at round limit", output: long, continued: true},
+		{name: "cut off inside edit markup", output: "Here:\n<new_file path=\"a.go\">\npackage", continued: true},
+		{name: "client max_tokens reached", maxTokens: 1000, output: strings.Repeat("word", 1000), finish: "length"},
+		{name: "rounds exhausted while cut off", rounds: 1, output: long, finish: "length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := model.OpenAIChatCompletionRequest{Model: "claude-3-7-sonnet", MaxTokens: tt.maxTokens}
+			auto := newAutoContinue(req, modelInfo)
+			auto.rounds = tt.rounds
+			r := newSectionRenderer(req)
+			r.render(textFrame(tt.output))
+			if got := auto.next(r, nil, req.Model); got != tt.continued {
+				t.Fatalf("continued %v, want %v", got, tt.continued)
+			}
+			if !tt.continued {
+				r.finish()
+				if got := r.finishReason(); got != tt.finish {
+					t.Errorf("finish_reason %q, want %q", got, tt.finish)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package controller
+
+import (
+	logger "alexsidebar2api/common/loggger"
+	"alexsidebar2api/model"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// conversationTurn 携带 conversation_id 的一轮对话, 成功后追加到会话
+type conversationTurn struct {
+	owner    string
+	id       string
+	messages []model.OpenAIChatMessage // 本轮新增的消息
+}
+
+// loadConversationTurn 将会话历史拼接到请求消息之前, 未携带 conversation_id 时返回 nil
+func loadConversationTurn(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequest) (*conversationTurn, error) {
+	if openAIReq.ConversationID == "" {
+		return nil, nil
+	}
+	owner := conversationOwner(c)
+	conversation, err := model.GetConversation(owner, openAIReq.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	turn := &conversationTurn{owner: owner, id: conversation.ID, messages: openAIReq.Messages}
+	openAIReq.Messages = append(append([]model.OpenAIChatMessage{}, conversation.Messages...), openAIReq.Messages...)
+	return turn, nil
+}
+
+// save 追加本轮消息及回复, 回复不含思考过程
+func (t *conversationTurn) save(c *gin.Context, modelName, output string) {
+	if t == nil {
+		return
+	}
+	reply := model.OpenAIChatMessage{Role: "assistant", Content: visibleOutput(output)}
+	if err := model.AppendConversation(t.owner, t.id, modelName, append(t.messages, reply)...); err != nil {
+		logger.Errorf(c.Request.Context(), "save conversation %s err: %v", t.id, err)
+	}
+}
+
+func conversationOwner(c *gin.Context) string {
+	return model.ConversationOwner(getApiKey(c).Key)
+}
+
+// ListConversations @Summary 会话列表
+// @Description 按更新时间倒序返回当前密钥的会话, 不含消息内容
+// @Tags OpenAI
+// @Produce json
+// @Param Authorization header string true "Authorization API-KEY"
+// @Success 200 {object} model.ConversationListResponse "成功"
+// @Router /v1/conversations [get]
+func ListConversations(c *gin.Context) {
+	conversations, err := model.ListConversations(conversationOwner(c))
+	if err != nil {
+		sendError(c, translateError(err))
+		return
+	}
+	c.JSON(http.StatusOK, model.ConversationListResponse{Object: "list", Data: conversations})
+}
+
+// CreateConversation @Summary 创建会话
+// @Description 可携带初始消息, 之后在对话请求中传入 conversation_id 即可只发送最新的消息
+// @Tags OpenAI
+// @Accept json
+// @Produce json
+// @Param req body model.CreateConversationRequest false "会话"
+// @Param Authorization header string true "Authorization API-KEY"
+// @Success 200 {object} model.Conversation "成功"
+// @Router /v1/conversations [post]
+func CreateConversation(c *gin.Context) {
+	var req model.CreateConversationRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			sendError(c, model.NewAPIError(http.StatusBadRequest, "invalid_request_body", err.Error()))
+			return
+		}
+	}
+	conversation, err := model.NewConversation(conversationOwner(c), req.Model, req.Title, req.Messages)
+	if err != nil {
+		sendError(c, translateError(err))
+		return
+	}
+	c.JSON(http.StatusOK, conversation)
+}
+
+// GetConversation @Summary 会话详情
+// @Description 会话详情, 包含全部消息
+// @Tags OpenAI
+// @Produce json
+// @Param id path string true "会话ID"
+// @Param Authorization header string true "Authorization API-KEY"
+// @Success 200 {object} model.Conversation "成功"
+// @Router /v1/conversations/{id} [get]
+func GetConversation(c *gin.Context) {
+	conversation, err := model.GetConversation(conversationOwner(c), c.Param("id"))
+	if err != nil {
+		sendError(c, translateError(err))
+		return
+	}
+	c.JSON(http.StatusOK, conversation)
+}
+
+// ForkConversation @Summary 分叉会话
+// @Description 复制会话的前 message_count 条消息为新会话, 0 表示全部
+// @Tags OpenAI
+// @Accept json
+// @Produce json
+// @Param id path string true "会话ID"
+// @Param req body model.ForkConversationRequest false "分叉位置"
+// @Param Authorization header string true "Authorization API-KEY"
+// @Success 200 {object} model.Conversation "成功"
+// @Router /v1/conversations/{id}/fork [post]
+func ForkConversation(c *gin.Context) {
+	var req model.ForkConversationRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			sendError(c, model.NewAPIError(http.StatusBadRequest, "invalid_request_body", err.Error()))
+			return
+		}
+	}
+	conversation, err := model.ForkConversation(conversationOwner(c), c.Param("id"), req.MessageCount)
+	if err != nil {
+		sendError(c, translateError(err))
+		return
+	}
+	c.JSON(http.StatusOK, conversation)
+}
+
+// DeleteConversation @Summary 删除会话
+// @Description 删除会话
+// @Tags OpenAI
+// @Produce json
+// @Param id path string true "会话ID"
+// @Param Authorization header string true "Authorization API-KEY"
+// @Success 200 {object} model.ConversationDeletedResponse "成功"
+// @Router /v1/conversations/{id} [delete]
+func DeleteConversation(c *gin.Context) {
+	id := c.Param("id")
+	if err := model.DeleteConversation(conversationOwner(c), id); err != nil {
+		sendError(c, translateError(err))
+		return
+	}
+	c.JSON(http.StatusOK, model.ConversationDeletedResponse{ID: id, Object: "conversation.deleted", Deleted: true})
+}
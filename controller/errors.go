@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/model"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errCookiesExhausted = errors.New("All cookies are temporarily unavailable.")
+
+// retryAfterSeconds 限流账号恢复前的等待时间, 没有限流账号时使用锁定时长
+func retryAfterSeconds() int {
+	d := config.RateLimitRetryAfter()
+	if d <= 0 {
+		d = time.Duration(config.RateLimitCookieLockDuration) * time.Second
+	}
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// upstreamAPIError 上游错误类别对应的对外错误
+func upstreamAPIError(class string) *model.APIError {
+	switch class {
+	case config.ErrorClassChineseChat:
+		return model.NewAPIError(http.StatusBadRequest, "chinese_chat",
+			"Detected that you are using Chinese for conversation, please use English for conversation.")
+	case config.ErrorClassUsageLimit, config.ErrorClassRateLimit:
+		return model.NewAPIError(http.StatusTooManyRequests, class, errCookiesExhausted.Error()).
+			WithRetryAfter(retryAfterSeconds())
+	case config.ErrorClassNotLogin:
+		return model.NewAPIError(http.StatusServiceUnavailable, class, errCookiesExhausted.Error())
+	case config.ErrorClassTimeout:
+		return model.NewAPIError(http.StatusRequestTimeout, "upstream_timeout", "Upstream timeout")
+	}
+	return model.NewAPIError(http.StatusBadGateway, class, fmt.Sprintf("Upstream request failed (%s)", class))
+}
+
+// translateError 将处理过程中的错误统一转换为对外错误
+func translateError(err error) *model.APIError {
+	var apiErr *model.APIError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, errCookiesExhausted), errors.Is(err, config.ErrNoCookies):
+		apiErr = model.NewAPIError(http.StatusServiceUnavailable, "no_available_account", errCookiesExhausted.Error())
+		if d := config.RateLimitRetryAfter(); d > 0 {
+			apiErr.WithRetryAfter(max(1, int(math.Ceil(d.Seconds()))))
+		}
+		return apiErr
+	case errors.Is(err, model.ErrConversationNotFound):
+		return model.NewAPIError(http.StatusNotFound, "conversation_not_found", err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return model.NewAPIError(http.StatusRequestTimeout, "timeout", err.Error())
+	case errors.Is(err, context.Canceled):
+		return model.NewAPIError(499, "client_closed_request", err.Error())
+	}
+	return model.NewAPIError(http.StatusInternalServerError, "internal_error", err.Error())
+}
+
+// sendError 以 OpenAI 格式返回错误, 流式响应使用 streamSession.fail
+func sendError(c *gin.Context, e *model.APIError) {
+	model.AbortWithOpenAIError(c, e)
+}
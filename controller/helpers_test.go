@@ -0,0 +1,144 @@
+package controller
+
+import (
+	alexsidebar_api "alexsidebar2api/alexsidebar-api"
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/common/store"
+	"alexsidebar2api/model"
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testCookie = "test-cookie"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	dir, err := os.MkdirTemp("", "controller-test")
+	if err != nil {
+		panic(err)
+	}
+	store.DataPath = dir
+	config.ASCookies = []string{testCookie}
+	config.ASTokenMap[testCookie] = config.ASTokenInfo{AccessToken: "test-token"}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestRouter 使用指定上游客户端的对话接口
+func newTestRouter(client alexsidebar_api.Client) *gin.Engine {
+	alexsidebar_api.SetDefaultClient(client)
+	router := gin.New()
+	router.POST("/v1/chat/completions", ChatForOpenAI)
+	return router
+}
+
+func doChat(router http.Handler, body string) *httptest.ResponseRecorder {
+	return doChatWithHeaders(router, body, nil)
+}
+
+func doChatWithHeaders(router http.Handler, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := &closeNotifyingRecorder{ResponseRecorder: httptest.NewRecorder(), closed: make(chan bool)}
+	router.ServeHTTP(rec, req)
+	return rec.ResponseRecorder
+}
+
+// closeNotifyingRecorder gin 的 c.Stream 要求 ResponseWriter 实现 http.CloseNotifier
+type closeNotifyingRecorder struct {
+	*httptest.ResponseRecorder
+	closed chan bool
+}
+
+func (r *closeNotifyingRecorder) CloseNotify() <-chan bool {
+	return r.closed
+}
+
+// chatResult 去掉 id、时间等易变字段后的回复
+type chatResult struct {
+	Content      string           `json:"content"`
+	FinishReason string           `json:"finish_reason"`
+	ToolCalls    []resultToolCall `json:"tool_calls,omitempty"`
+	Error        string           `json:"error,omitempty"`
+}
+
+type resultToolCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// parseChatResult 解析流式或非流式响应
+func parseChatResult(t *testing.T, rec *httptest.ResponseRecorder) chatResult {
+	t.Helper()
+	var result chatResult
+	addToolCalls := func(calls []model.OpenAIToolCall) {
+		for _, call := range calls {
+			result.ToolCalls = append(result.ToolCalls, resultToolCall{Name: call.Function.Name, Arguments: call.Function.Arguments})
+		}
+	}
+
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/event-stream") {
+		var resp struct {
+			model.OpenAIChatCompletionResponse
+			Error *model.OpenAIError `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+		}
+		if resp.Error != nil {
+			result.Error = resp.Error.Code
+			return result
+		}
+		choice := resp.Choices[0]
+		result.Content = choice.Message.Content
+		if choice.FinishReason != nil {
+			result.FinishReason = *choice.FinishReason
+		}
+		addToolCalls(choice.Message.ToolCalls)
+		return result
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(rec.Body.String()))
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk struct {
+			model.OpenAIChatCompletionResponse
+			Error *model.OpenAIError `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("decode chunk %q: %v", data, err)
+		}
+		if chunk.Error != nil {
+			result.Error = chunk.Error.Code
+			continue
+		}
+		for _, choice := range chunk.Choices {
+			result.Content += choice.Delta.Content
+			addToolCalls(choice.Delta.ToolCalls)
+			if choice.FinishReason != nil {
+				result.FinishReason = *choice.FinishReason
+			}
+		}
+	}
+	return result
+}
+
+// textFrame 只含一个文本段的上游帧
+func textFrame(text string) alexsidebar_api.Event {
+	return alexsidebar_api.Event{Sections: []alexsidebar_api.Section{{Text: &alexsidebar_api.TextSection{Text: text}}}}
+}
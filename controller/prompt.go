@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/model"
+	"strings"
+	"time"
+)
+
+// promptVariables 模板中可用的变量
+func promptVariables(openAIReq model.OpenAIChatCompletionRequest, apiKey config.ApiKey) *strings.Replacer {
+	now := time.Now()
+	return strings.NewReplacer(
+		"{{date}}", now.Format("2006-01-02"),
+		"{{time}}", now.Format("15:04:05"),
+		"{{weekday}}", now.Weekday().String(),
+		"{{model}}", openAIReq.Model,
+		"{{user}}", apiKey.Name,
+	)
+}
+
+// applyPromptTemplates 按配置顺序应用对模型生效的模板, 返回生效的模板数
+// 模板的 system 依次放在客户端消息之前, 预置消息插入在最后一条 system 消息之后
+func applyPromptTemplates(openAIReq *model.OpenAIChatCompletionRequest, apiKey config.ApiKey) int {
+	if openAIReq.SkipPromptTemplate {
+		return 0
+	}
+	templates := config.PromptTemplatesFor(openAIReq.Model)
+	if len(templates) == 0 {
+		return 0
+	}
+
+	vars := promptVariables(*openAIReq, apiKey)
+	var systems, presets []model.OpenAIChatMessage
+	for _, template := range templates {
+		if template.System != "" {
+			systems = append(systems, model.OpenAIChatMessage{Role: "system", Content: vars.Replace(template.System)})
+		}
+		for _, message := range template.Messages {
+			presets = append(presets, model.OpenAIChatMessage{Role: message.Role, Content: replaceContentVariables(message.Content, vars)})
+		}
+	}
+	openAIReq.Messages = append(systems, openAIReq.Messages...)
+	openAIReq.PrependMessages(presets)
+	openAIReq.PinnedMessages += len(presets)
+	return len(templates)
+}
+
+// replaceContentVariables 替换字符串内容及内容数组中 text 部分的变量, 返回副本, 不修改模板本身
+func replaceContentVariables(content interface{}, vars *strings.Replacer) interface{} {
+	switch content := content.(type) {
+	case string:
+		return vars.Replace(content)
+	case []interface{}:
+		parts := make([]interface{}, len(content))
+		for i, part := range content {
+			parts[i] = part
+			part, ok := part.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			text, ok := part["text"].(string)
+			if !ok || part["type"] != "text" {
+				continue
+			}
+			replaced := make(map[string]interface{}, len(part))
+			for k, v := range part {
+				replaced[k] = v
+			}
+			replaced["text"] = vars.Replace(text)
+			parts[i] = replaced
+		}
+		return parts
+	}
+	return content
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	alexsidebar_api "alexsidebar2api/alexsidebar-api"
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setPreMessages 以 PRE_MESSAGES_JSON 加载模板, 测试结束后恢复
+func setPreMessages(t *testing.T, preMessages string) {
+	t.Helper()
+	previous := config.PRE_MESSAGES_JSON
+	t.Cleanup(func() {
+		config.PRE_MESSAGES_JSON = previous
+		config.InitPromptTemplates()
+	})
+	config.PRE_MESSAGES_JSON = preMessages
+	if err := config.InitPromptTemplates(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestPromptTemplatesArrayContent 预置消息支持内容数组, 只替换 text 部分的变量且不修改模板
+func TestPromptTemplatesArrayContent(t *testing.T) {
+	setPreMessages(t, `[{"role":"user","content":[{"type":"text","text":"You are {{model}}."},{"type":"image_url","image_url":{"url":"data:{{model}}"}}]}]`)
+
+	for i := 0; i < 2; i++ {
+		req := model.OpenAIChatCompletionRequest{
+			Model:    "claude-3-7-sonnet",
+			Messages: []model.OpenAIChatMessage{{Role: "user", Content: "hi"}},
+		}
+		if n := applyPromptTemplates(&req, config.ApiKey{}); n != 1 || req.PinnedMessages != 1 {
+			t.Fatalf("expected 1 template and 1 pinned message, got %d and %d", n, req.PinnedMessages)
+		}
+		parts, ok := req.Messages[0].Content.([]interface{})
+		if !ok || len(parts) != 2 {
+			t.Fatalf("unexpected preset content %#v", req.Messages[0].Content)
+		}
+		if text := parts[0].(map[string]interface{})["text"]; text != "You are claude-3-7-sonnet." {
+			t.Errorf("text part not replaced: %v", text)
+		}
+		if url := parts[1].(map[string]interface{})["image_url"].(map[string]interface{})["url"]; url != "data:{{model}}" {
+			t.Errorf("non-text part should be kept as is: %v", url)
+		}
+	}
+}
+
+// TestPreviewManagesContext 预览返回上下文管理后的最终提示词
+func TestPreviewManagesContext(t *testing.T) {
+	defer func(strategy string, limit int) {
+		config.ContextStrategy, config.ContextMaxTokens = strategy, limit
+	}(config.ContextStrategy, config.ContextMaxTokens)
+	config.ContextStrategy = contextStrategyDropOldest
+	config.ContextMaxTokens = 400
+	setPreMessages(t, `[{"role":"user","content":"Preset question"},{"role":"assistant","content":"Preset answer"}]`)
+
+	fake := &alexsidebar_api.FakeClient{Record: true}
+	alexsidebar_api.SetDefaultClient(fake)
+	router := gin.New()
+	router.POST("/v1/chat/completions/preview", PreviewChat)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"model": "claude-3-7-sonnet",
+		"messages": []map[string]string{
+			{"role": "user", "content": strings.Repeat("old ", 200)},
+			{"role": "assistant", "content": "old answer"},
+			{"role": "user", "content": "latest"},
+		},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions/preview", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var preview model.ChatPreviewResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &preview); err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, message := range preview.Messages {
+		contents = append(contents, message.TextContent())
+	}
+	if got := strings.Join(contents, "|"); got != "Preset question|Preset answer|latest" {
+		t.Errorf("unexpected messages %q", got)
+	}
+	if preview.ContextManagement == "" || rec.Header().Get("X-Context-Management") != preview.ContextManagement {
+		t.Errorf("expected context management report, got %q and header %q", preview.ContextManagement, rec.Header().Get("X-Context-Management"))
+	}
+	if len(fake.Requests) != 0 {
+		t.Errorf("preview should not call upstream, got %d requests", len(fake.Requests))
+	}
+}
@@ -0,0 +1,297 @@
+package controller
+
+import (
+	alexsidebar_api "alexsidebar2api/alexsidebar-api"
+	"alexsidebar2api/common/random"
+	"alexsidebar2api/model"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// applyEditTool 客户端声明该工具时, 上游的文件修改以 tool_calls 返回
+const applyEditTool = "apply_edit"
+
+// sectionRenderer 将上游各段的增量渲染为输出文本, 流式与非流式共用
+// 代码段渲染为带语言和文件路径的 Markdown 代码块
+type sectionRenderer struct {
+	tracker   alexsidebar_api.SectionTracker
+	thinking  bool // <think> 已打开
+	fence     bool // 代码块已打开
+	newline   bool // 已输出内容以换行结尾
+	lastIndex int  // 上一次输出内容的段
+	lastKind  string
+	written   bool
+
+	output     strings.Builder // 已返回的全部文本
+	roundStart int             // 本轮请求输出的起始位置
+	truncated  bool            // 达到 max_tokens 或续写轮数上限
+	splicer    *continuationSplicer
+	resumeKind string // 续写时首个段与中断的段类型相同则接着输出, 不另起段
+
+	withCodeBlocks bool
+	codes          map[int]alexsidebar_api.CodeSection
+	carriedCodes   []model.CodeBlock // 续写前已输出的代码块
+
+	withToolCalls bool
+	last          alexsidebar_api.Event
+	editScanners  map[int]*alexsidebar_api.EditScanner // 各文本段的修改标记, 逐帧只扫描新增部分
+	textEdits     map[int]int                          // 各文本段已转换的修改数
+	codeEdits     map[int]alexsidebar_api.FileEdit     // 带文件路径的代码段, 出现后续段或结束时转换
+	codeEditsDone map[int]bool
+	toolCalls     []model.OpenAIToolCall
+	sentToolCalls int
+}
+
+func newSectionRenderer(openAIReq model.OpenAIChatCompletionRequest) *sectionRenderer {
+	return &sectionRenderer{
+		lastIndex:      -1,
+		withCodeBlocks: openAIReq.CodeBlocks,
+		codes:          map[int]alexsidebar_api.CodeSection{},
+		withToolCalls:  openAIReq.HasTool(applyEditTool),
+		editScanners:   map[int]*alexsidebar_api.EditScanner{},
+		textEdits:      map[int]int{},
+		codeEdits:      map[int]alexsidebar_api.FileEdit{},
+		codeEditsDone:  map[int]bool{},
+	}
+}
+
+// continueFrom 接续中断的渲染器, 续写内容紧接已输出内容并去掉重复部分
+func (r *sectionRenderer) continueFrom(prev *sectionRenderer) {
+	r.thinking = prev.thinking
+	r.fence = prev.fence
+	r.newline = prev.newline
+	r.written = prev.written
+	r.resumeKind = prev.lastKind
+	r.output.WriteString(prev.output.String())
+	r.roundStart = r.output.Len()
+	r.splicer = newContinuationSplicer(visibleOutput(prev.output.String()))
+	r.carriedCodes = prev.codeBlocks()
+	r.toolCalls = prev.toolCalls
+	r.sentToolCalls = prev.sentToolCalls
+}
+
+// render 处理一帧, 返回需要输出的文本
+func (r *sectionRenderer) render(event alexsidebar_api.Event) string {
+	r.last = event
+	return r.emit(r.renderEvent(r.extractEdits(event, false)), false)
+}
+
+func (r *sectionRenderer) emit(out string, final bool) string {
+	if r.splicer != nil {
+		out = r.splicer.splice(out, final)
+	}
+	r.output.WriteString(out)
+	return out
+}
+
+func (r *sectionRenderer) renderEvent(event alexsidebar_api.Event) string {
+	return r.renderDeltas(r.tracker.Update(event))
+}
+
+func (r *sectionRenderer) renderDeltas(deltas []alexsidebar_api.SectionDelta) string {
+	var sb strings.Builder
+	for _, delta := range deltas {
+		if r.resumeKind != "" {
+			if delta.Kind() == r.resumeKind {
+				r.lastIndex = delta.Index
+			}
+			r.resumeKind = ""
+		}
+		if r.fence && delta.Index != r.lastIndex {
+			sb.WriteString(r.closeFence())
+		}
+
+		if delta.Thinking() && !r.thinking && !r.written {
+			sb.WriteString("<think>\n\n")
+			r.thinking = true
+		} else if !delta.Thinking() && r.thinking {
+			sb.WriteString("\n\n</think>\n\n")
+			r.thinking = false
+		} else if r.written && delta.Index != r.lastIndex {
+			// 段之间以空行分隔
+			sb.WriteString("\n\n")
+		} else if delta.Rewrite {
+			// 已输出的内容无法撤回, 另起一行输出改写后的完整内容
+			sb.WriteString("\n")
+		}
+
+		if code := delta.Section.Code; code != nil {
+			if !r.fence {
+				sb.WriteString(codeFence(*code))
+				r.fence = true
+			}
+			if r.withCodeBlocks {
+				r.codes[delta.Index] = *code
+			}
+		}
+
+		sb.WriteString(delta.Content)
+		r.newline = strings.HasSuffix(delta.Content, "\n")
+		r.lastIndex = delta.Index
+		r.lastKind = delta.Kind()
+		r.written = true
+	}
+	return sb.String()
+}
+
+// finish 上游结束时补齐未闭合的标记
+func (r *sectionRenderer) finish() string {
+	var sb strings.Builder
+	last := r.last
+	if r.withToolCalls {
+		// 输出暂缓的未闭合标记, 代码段的修改此时已完整
+		last = r.extractEdits(r.last, true)
+		sb.WriteString(r.renderEvent(last))
+		r.flushCodeEdits(len(r.last.Sections))
+	}
+	// 输出暂缓的改写
+	sb.WriteString(r.renderDeltas(r.tracker.Flush(last)))
+	if r.fence {
+		sb.WriteString(r.closeFence())
+	}
+	if r.thinking {
+		r.thinking = false
+		sb.WriteString("\n\n</think>\n\n")
+	}
+	return r.emit(sb.String(), true)
+}
+
+// codeFence 代码块起始行, 语言之后附带文件路径
+func codeFence(code alexsidebar_api.CodeSection) string {
+	info := strings.TrimSpace(code.Language + " " + code.FilePath)
+	return "```" + info + "\n"
+}
+
+func (r *sectionRenderer) closeFence() string {
+	r.fence = false
+	if r.newline {
+		return "```"
+	}
+	return "\n```"
+}
+
+// codeBlocks 按段顺序返回全部代码段, 未开启 code_blocks 扩展时返回 nil
+func (r *sectionRenderer) codeBlocks() []model.CodeBlock {
+	if !r.withCodeBlocks || len(r.codes)+len(r.carriedCodes) == 0 {
+		return nil
+	}
+	indexes := make([]int, 0, len(r.codes))
+	for i := range r.codes {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	blocks := append(make([]model.CodeBlock, 0, len(r.carriedCodes)+len(indexes)), r.carriedCodes...)
+	for _, i := range indexes {
+		code := r.codes[i]
+		blocks = append(blocks, model.CodeBlock{
+			Language: code.Language,
+			FilePath: code.FilePath,
+			Code:     code.Code,
+		})
+	}
+	return blocks
+}
+
+// extractEdits 开启 apply_edit 时将修改标记和带文件路径的代码段从输出中移除并转换为工具调用
+func (r *sectionRenderer) extractEdits(event alexsidebar_api.Event, final bool) alexsidebar_api.Event {
+	if !r.withToolCalls {
+		return event
+	}
+	sections := make([]alexsidebar_api.Section, len(event.Sections))
+	for i, section := range event.Sections {
+		// 之前的代码段已不再变化
+		r.flushCodeEdits(i)
+		switch {
+		case section.Text != nil:
+			scanner, ok := r.editScanners[i]
+			if !ok {
+				scanner = &alexsidebar_api.EditScanner{}
+				r.editScanners[i] = scanner
+			}
+			visible, edits := scanner.Scan(section.Text.Text, final)
+			for _, edit := range edits[min(r.textEdits[i], len(edits)):] {
+				r.addToolCall(edit)
+			}
+			r.textEdits[i] = max(r.textEdits[i], len(edits))
+			text := *section.Text
+			text.Text = visible
+			section.Text = &text
+		case section.Code != nil && section.Code.FilePath != "":
+			if !r.codeEditsDone[i] {
+				r.codeEdits[i] = alexsidebar_api.FileEdit{
+					Path:     section.Code.FilePath,
+					Content:  section.Code.Code,
+					Language: section.Code.Language,
+				}
+			}
+			section = alexsidebar_api.Section{}
+		}
+		sections[i] = section
+	}
+	return alexsidebar_api.Event{Sections: sections, Done: event.Done}
+}
+
+// flushCodeEdits 转换索引小于 before 的代码段修改
+func (r *sectionRenderer) flushCodeEdits(before int) {
+	indexes := make([]int, 0, len(r.codeEdits))
+	for i := range r.codeEdits {
+		if i < before {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		r.addToolCall(r.codeEdits[i])
+		delete(r.codeEdits, i)
+		r.codeEditsDone[i] = true
+	}
+}
+
+func (r *sectionRenderer) addToolCall(edit alexsidebar_api.FileEdit) {
+	arguments, _ := json.Marshal(edit)
+	r.toolCalls = append(r.toolCalls, model.OpenAIToolCall{
+		Index: len(r.toolCalls),
+		ID:    "call_" + random.GetRandomString(24),
+		Type:  "function",
+		Function: model.OpenAIToolCallFunction{
+			Name:      applyEditTool,
+			Arguments: string(arguments),
+		},
+	})
+}
+
+// takeToolCalls 返回上次调用之后新增的工具调用
+func (r *sectionRenderer) takeToolCalls() []model.OpenAIToolCall {
+	calls := r.toolCalls[r.sentToolCalls:]
+	r.sentToolCalls = len(r.toolCalls)
+	return calls
+}
+
+// roundOutput 本轮请求的输出
+func (r *sectionRenderer) roundOutput() string {
+	return r.output.String()[r.roundStart:]
+}
+
+// pendingEdit 最后一个文本段以未闭合的修改标记结束
+func (r *sectionRenderer) pendingEdit() bool {
+	section, ok := r.last.LastSection()
+	if !ok || section.Text == nil {
+		return false
+	}
+	partial, _ := alexsidebar_api.ExtractEdits(section.Text.Text, false)
+	final, _ := alexsidebar_api.ExtractEdits(section.Text.Text, true)
+	return partial != final
+}
+
+// finishReason 输出被截断时为 length, 有工具调用时为 tool_calls
+func (r *sectionRenderer) finishReason() string {
+	if r.truncated {
+		return "length"
+	}
+	if len(r.toolCalls) > 0 {
+		return "tool_calls"
+	}
+	return "stop"
+}
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	alexsidebar_api "alexsidebar2api/alexsidebar-api"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files from the current output")
+
+// TestReplayGolden 回放 testdata/replay 中录制的上游流, 对比经过对话接口后的输出
+// 新增场景: 以 MOCK_UPSTREAM_ENABLE 及 UPSTREAM_CAPTURE_FILE 运行并发送 <name>.json, 录制追加到 captures.jsonl 后执行 go test -update
+func TestReplayGolden(t *testing.T) {
+	records, err := alexsidebar_api.LoadCaptures("testdata/replay/captures.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, err := filepath.Glob("testdata/replay/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) == 0 {
+		t.Fatal("no replay requests found")
+	}
+
+	for _, path := range requests {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			body, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			router := newTestRouter(alexsidebar_api.NewReplayer(records))
+			rec := doChat(router, string(body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			got, err := json.MarshalIndent(parseChatResult(t, rec), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := strings.TrimSuffix(path, ".json") + ".golden"
+			if *updateGolden {
+				if err := os.WriteFile(golden, append(got, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(string(want)) != string(got) {
+				t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestReplayMissFails(t *testing.T) {
+	records, err := alexsidebar_api.LoadCaptures("testdata/replay/captures.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newTestRouter(alexsidebar_api.NewReplayer(records))
+	rec := doChat(router, `{"model":"claude-3-7-sonnet","messages":[{"role":"user","content":"never captured"}]}`)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 on replay miss, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
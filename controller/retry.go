@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"alexsidebar2api/common"
+	"alexsidebar2api/common/config"
+	logger "alexsidebar2api/common/loggger"
+	"alexsidebar2api/cycletls"
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// classifyUpstreamError 根据上游错误内容和状态码判断错误类别
+func classifyUpstreamError(response cycletls.SSEResponse) string {
+	data := response.Data
+	switch {
+	case common.IsUsageLimitExceeded(data):
+		return config.ErrorClassUsageLimit
+	case common.IsChineseChat(data):
+		return config.ErrorClassChineseChat
+	case common.IsNotLogin(data):
+		return config.ErrorClassNotLogin
+	case common.IsRateLimit(data):
+		return config.ErrorClassRateLimit
+	case common.IsUpstreamTimeout(data):
+		return config.ErrorClassTimeout
+	case common.IsCloudflareBlock(data):
+		return config.ErrorClassCloudflareBlock
+	case common.IsCloudflareChallenge(data):
+		return config.ErrorClassCloudflareChallenge
+	case common.IsServerError(data), response.Status >= http.StatusInternalServerError:
+		return config.ErrorClassServerError
+	case common.IsNetworkError(data), response.Status == 0:
+		return config.ErrorClassNetwork
+	}
+	return config.ErrorClassUnknown
+}
+
+// retryState 单个请求的重试状态, 重试次数上限与账号池大小无关
+type retryState struct {
+	ctx           context.Context
+	cookieManager *config.CookieManager
+	cookie        string
+	excluded      map[string]bool // 本次请求中已不可用的账号
+	proxyIndex    int
+	attempt       int
+}
+
+func newRetryState(ctx context.Context, accountGroup string) (*retryState, error) {
+	cookieManager := config.NewCookieManagerForGroup(accountGroup)
+	cookie, err := cookieManager.GetRandomCookie()
+	if err != nil {
+		return nil, err
+	}
+	r := &retryState{
+		ctx:           ctx,
+		cookieManager: cookieManager,
+		cookie:        cookie,
+		excluded:      map[string]bool{},
+	}
+	if len(config.ProxyUrls) > 0 {
+		r.proxyIndex = rand.Intn(len(config.ProxyUrls))
+	}
+	return r, nil
+}
+
+func (r *retryState) proxy() string {
+	if len(config.ProxyUrls) == 0 {
+		return ""
+	}
+	return config.ProxyUrls[r.proxyIndex%len(config.ProxyUrls)]
+}
+
+// next 处理一次失败并准备下一次尝试, 返回 error 表示放弃重试
+func (r *retryState) next(class string) error {
+	r.attempt++
+
+	// 账号相关的副作用与重试策略无关
+	switch class {
+	case config.ErrorClassUsageLimit:
+		config.RemoveCookie(r.cookie)
+		r.excluded[r.cookie] = true
+	case config.ErrorClassNotLogin:
+		r.excluded[r.cookie] = true
+	case config.ErrorClassRateLimit:
+		config.AddRateLimitCookie(r.cookie, time.Now().Add(time.Duration(config.RateLimitCookieLockDuration)*time.Second))
+		r.excluded[r.cookie] = true
+	}
+
+	action := config.GetRetryAction(class)
+	if action == config.RetryFail {
+		return upstreamAPIError(class)
+	}
+	if r.attempt >= config.RetryMaxAttempts {
+		logger.Errorf(r.ctx, "Retry budget exhausted after %d attempts, last error: %s", r.attempt, class)
+		return upstreamAPIError(class)
+	}
+
+	if action == config.RetrySwitchProxy && len(config.ProxyUrls) <= 1 {
+		action = config.RetrySwitchAcct
+	}
+	if r.excluded[r.cookie] {
+		action = config.RetrySwitchAcct
+	}
+
+	switch action {
+	case config.RetrySwitchProxy:
+		r.proxyIndex++
+		logger.Warnf(r.ctx, "Upstream error %s, switching proxy, attempt %d/%d", class, r.attempt, config.RetryMaxAttempts)
+	case config.RetrySwitchAcct:
+		if err := r.nextCookie(); err != nil {
+			// 没有其他账号可用, 返回最后一次的上游错误
+			return upstreamAPIError(class)
+		}
+		logger.Warnf(r.ctx, "Upstream error %s, switching to next cookie, attempt %d/%d", class, r.attempt, config.RetryMaxAttempts)
+	default:
+		logger.Warnf(r.ctx, "Upstream error %s, retrying with same cookie, attempt %d/%d", class, r.attempt, config.RetryMaxAttempts)
+	}
+
+	return r.backoff()
+}
+
+// failover 流式输出中断后准备续写, 有其他账号时换账号, 否则按重试策略处理
+func (r *retryState) failover(class string) error {
+	if config.GetRetryAction(class) != config.RetryFail && len(r.cookieManager.Cookies) > len(r.excluded)+1 {
+		r.excluded[r.cookie] = true
+	}
+	return r.next(class)
+}
+
+// nextCookie 选择下一个未被排除的账号
+func (r *retryState) nextCookie() error {
+	for range r.cookieManager.Cookies {
+		cookie, err := r.cookieManager.GetNextCookie()
+		if err != nil {
+			return errCookiesExhausted
+		}
+		if !r.excluded[cookie] {
+			r.cookie = cookie
+			return nil
+		}
+	}
+	return errCookiesExhausted
+}
+
+// backoff 指数退避, 在 [d/2, d) 范围内随机抖动
+func (r *retryState) backoff() error {
+	d := time.Duration(config.RetryBackoffBaseMs) * time.Millisecond << (r.attempt - 1)
+	if max := time.Duration(config.RetryBackoffMaxMs) * time.Millisecond; d > max || d <= 0 {
+		d = max
+	}
+	if d > 0 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+
+	select {
+	case <-time.After(d):
+		return nil
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	}
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/model"
+	"fmt"
+	"strings"
+)
+
+// system/developer 消息的处理方式
+const (
+	systemStrategyPrompt = "prompt"
+	systemStrategyInline = "inline"
+)
+
+// mapRoles 将客户端消息转换为上游接受的 prompt 及 user/assistant 消息
+// system/developer 按 SYSTEM_MESSAGE_STRATEGY 处理, tool/function 结果及 assistant 的工具调用转换为文本,
+// 转换后相邻的同角色消息合并
+func mapRoles(messages []model.OpenAIChatMessage) (string, []model.OpenAIChatMessage) {
+	var prompts []string
+	var mapped []model.OpenAIChatMessage
+	toolNames := map[string]string{}
+
+	add := func(role string, content interface{}) {
+		if isEmptyContent(content) {
+			return
+		}
+		if n := len(mapped); n > 0 && mapped[n-1].Role == role {
+			mapped[n-1].Content = mergeContent(mapped[n-1].Content, content)
+			return
+		}
+		mapped = append(mapped, model.OpenAIChatMessage{Role: role, Content: content})
+	}
+
+	for _, message := range messages {
+		switch strings.ToLower(message.Role) {
+		case "system", "developer":
+			text := message.TextContent()
+			if text == "" {
+				continue
+			}
+			if config.SystemMessageStrategy == systemStrategyInline {
+				add("user", "System instructions:\n"+text)
+			} else {
+				prompts = append(prompts, text)
+			}
+		case "assistant":
+			content := message.Content
+			for _, call := range message.ToolCalls {
+				toolNames[call.ID] = call.Function.Name
+				content = mergeContent(content, fmt.Sprintf("Called tool `%s` with arguments: %s", call.Function.Name, call.Function.Arguments))
+			}
+			add("assistant", content)
+		case "tool", "function":
+			name := message.Name
+			if name == "" {
+				name = toolNames[message.ToolCallID]
+			}
+			header := "Tool result"
+			if name != "" {
+				header = fmt.Sprintf("Result of tool `%s`", name)
+			}
+			add("user", header+":\n"+message.TextContent())
+		default:
+			add("user", message.Content)
+		}
+	}
+	return strings.Join(prompts, "\n\n"), mapped
+}
+
+func isEmptyContent(content interface{}) bool {
+	switch v := content.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// mergeContent 合并两段内容, 均为文本时以空行连接, 否则合并为多段内容
+func mergeContent(a, b interface{}) interface{} {
+	if isEmptyContent(a) {
+		return b
+	}
+	if isEmptyContent(b) {
+		return a
+	}
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok {
+		return as + "\n\n" + bs
+	}
+	return append(append([]interface{}{}, contentParts(a)...), contentParts(b)...)
+}
+
+func contentParts(content interface{}) []interface{} {
+	switch v := content.(type) {
+	case string:
+		return []interface{}{map[string]interface{}{"type": "text", "text": v}}
+	case []interface{}:
+		return v
+	}
+	return nil
+}
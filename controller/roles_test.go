@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/model"
+	"testing"
+)
+
+// TestMapRolesSkipsEmptySystem 空的 system 消息不产生 prompt 或 user 消息
+func TestMapRolesSkipsEmptySystem(t *testing.T) {
+	defer func(strategy string) { config.SystemMessageStrategy = strategy }(config.SystemMessageStrategy)
+
+	messages := []model.OpenAIChatMessage{
+		{Role: "system", Content: ""},
+		{Role: "developer", Content: []interface{}{}},
+		{Role: "user", Content: "hi"},
+	}
+	for _, strategy := range []string{systemStrategyPrompt, systemStrategyInline} {
+		config.SystemMessageStrategy = strategy
+		prompt, mapped := mapRoles(messages)
+		if prompt != "" || len(mapped) != 1 || mapped[0].TextContent() != "hi" {
+			t.Errorf("%s: unexpected prompt %q and messages %+v", strategy, prompt, mapped)
+		}
+	}
+}
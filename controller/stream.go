@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"alexsidebar2api/common/config"
+	logger "alexsidebar2api/common/loggger"
+	"alexsidebar2api/model"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errStreamClosed = errors.New("stream already closed")
+
+// streamSession 流式响应的输出状态
+// 响应头发送前出错时以对应状态码返回 JSON 错误, 发送后只能以流内错误事件结束
+type streamSession struct {
+	c              *gin.Context
+	headersFlushed bool
+	contentSent    bool // 已输出内容, 之后不能再换账号重试
+	completed      bool // 正常结束
+	closed         bool
+	flight         *flight // 合并请求的发起者, 已输出的内容同时转发给跟随者
+}
+
+func newStreamSession(c *gin.Context) *streamSession {
+	return &streamSession{c: c}
+}
+
+// flushHeaders 发送 SSE 响应头
+func (s *streamSession) flushHeaders() {
+	if s.headersFlushed {
+		return
+	}
+	s.c.Header("Content-Type", "text/event-stream")
+	s.c.Header("Cache-Control", "no-cache")
+	s.c.Header("Connection", "keep-alive")
+	s.c.Header("X-Accel-Buffering", "no")
+	s.c.Status(http.StatusOK)
+	s.c.Writer.WriteHeaderNow()
+	s.c.Writer.Flush()
+	s.headersFlushed = true
+}
+
+// send 发送数据事件
+func (s *streamSession) send(response interface{}) error {
+	if s.closed {
+		return errStreamClosed
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		logger.Errorf(s.c.Request.Context(), "Failed to marshal response: %v", err)
+		return err
+	}
+	return s.write(data)
+}
+
+// sendContent 发送携带内容的数据事件
+func (s *streamSession) sendContent(response interface{}) error {
+	if err := s.send(response); err != nil {
+		return err
+	}
+	s.contentSent = true
+	return nil
+}
+
+// comment 发送 SSE 注释行, 客户端会忽略
+func (s *streamSession) comment(text string) error {
+	if s.closed {
+		return errStreamClosed
+	}
+	return s.writeRaw([]byte(": " + text + "\n\n"))
+}
+
+// done 正常结束流
+func (s *streamSession) done() {
+	if s.closed {
+		return
+	}
+	_ = s.write([]byte("[DONE]"))
+	s.completed = true
+	s.closed = true
+}
+
+// fail 以错误结束流
+func (s *streamSession) fail(e *model.APIError) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if !s.headersFlushed {
+		model.AbortWithOpenAIError(s.c, e)
+		return
+	}
+
+	data, err := json.Marshal(e.OpenAI())
+	if err == nil {
+		if err = s.write(data); err == nil {
+			err = s.write([]byte("[DONE]"))
+		}
+	}
+	if err != nil {
+		logger.Warnf(s.c.Request.Context(), "Failed to send error event: %v", err)
+	}
+	s.c.Abort()
+}
+
+func (s *streamSession) write(data []byte) error {
+	buf := make([]byte, 0, len(data)+8)
+	buf = append(buf, "data: "...)
+	buf = append(buf, data...)
+	buf = append(buf, "\n\n"...)
+	return s.writeRaw(buf)
+}
+
+// writeRaw 合并请求的发起者客户端断开后丢弃输出, 继续读取上游为跟随者转发
+func (s *streamSession) writeRaw(buf []byte) error {
+	if s.flight.detached(s.c) {
+		return nil
+	}
+	s.flushHeaders()
+	if _, err := s.c.Writer.Write(buf); err != nil {
+		if s.flight.detached(s.c) {
+			return nil
+		}
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+// heartbeat 上游静默超过间隔时触发, 收到上游帧后重新计时
+type heartbeat struct {
+	interval time.Duration
+	timer    *time.Timer
+}
+
+func newHeartbeat() *heartbeat {
+	h := &heartbeat{interval: time.Duration(config.StreamHeartbeatInterval) * time.Second}
+	if h.interval > 0 {
+		h.timer = time.NewTimer(h.interval)
+	}
+	return h
+}
+
+// C 心跳关闭时返回 nil, 在 select 中永不触发
+func (h *heartbeat) C() <-chan time.Time {
+	if h.timer == nil {
+		return nil
+	}
+	return h.timer.C
+}
+
+func (h *heartbeat) Reset() {
+	if h.timer == nil {
+		return
+	}
+	if !h.timer.Stop() {
+		select {
+		case <-h.timer.C:
+		default:
+		}
+	}
+	h.timer.Reset(h.interval)
+}
+
+func (h *heartbeat) Stop() {
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+}
+
+// sendHeartbeat 按配置发送注释行或空内容的 chunk
+func sendHeartbeat(session *streamSession, responseId, modelName string) error {
+	if config.StreamHeartbeatType == "delta" {
+		return session.send(createStreamResponse(responseId, modelName, nil, model.OpenAIDelta{}, nil))
+	}
+	return session.comment("ping")
+}
@@ -12,6 +12,7 @@ type Browser struct {
 	// Return a greeting that embeds the name in a message.
 	JA3                string
 	UserAgent          string
+	ClientType         string // 为空时按 UserAgent 推断
 	Cookies            []Cookie
 	InsecureSkipVerify bool
 	forceHTTP1         bool
@@ -94,3 +94,20 @@ func convertFHTTPCookiesToNetHTTPCookies(fhttpCookies []*http.Cookie) []*nhttp.C
 	}
 	return netHTTPCookies
 }
+
+// toHTTPCookie 转换为 fhttp 的 Cookie
+func (properties Cookie) toHTTPCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:       properties.Name,
+		Value:      properties.Value,
+		Path:       properties.Path,
+		Domain:     properties.Domain,
+		Expires:    properties.JSONExpires.Time, //TODO: scuffed af
+		RawExpires: properties.RawExpires,
+		MaxAge:     properties.MaxAge,
+		HttpOnly:   properties.HTTPOnly,
+		Secure:     properties.Secure,
+		Raw:        properties.Raw,
+		Unparsed:   properties.Unparsed,
+	}
+}
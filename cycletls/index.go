@@ -3,6 +3,7 @@ package cycletls
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -15,6 +16,8 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // Options sets CycleTLS client options
@@ -25,9 +28,11 @@ type Options struct {
 	Body               string            `json:"body"`
 	Ja3                string            `json:"ja3"`
 	UserAgent          string            `json:"userAgent"`
+	ClientType         string            `json:"clientType"` // chrome/firefox/safari, 为空时按 UserAgent 推断
 	Proxy              string            `json:"proxy"`
 	Cookies            []Cookie          `json:"cookies"`
 	Timeout            int               `json:"timeout"`
+	ConnectTimeout     int               `json:"connectTimeout"` // 收到响应头前的超时(秒), 仅 DoSSE 使用
 	DisableRedirect    bool              `json:"disableRedirect"`
 	HeaderOrder        []string          `json:"headerOrder"`
 	OrderAsProvided    bool              `json:"orderAsProvided"` //TODO
@@ -43,7 +48,7 @@ type cycleTLSRequest struct {
 // rename to request+client+options
 type fullRequest struct {
 	req     *http.Request
-	client  http.Client
+	client  *http.Client
 	options cycleTLSRequest
 }
 
@@ -74,29 +79,15 @@ type CycleTLS struct {
 }
 
 // ready Request
-func processRequest(request cycleTLSRequest) (result fullRequest) {
-	var browser = Browser{
-		JA3:                request.Options.Ja3,
-		UserAgent:          request.Options.UserAgent,
-		Cookies:            request.Options.Cookies,
-		InsecureSkipVerify: request.Options.InsecureSkipVerify,
-		forceHTTP1:         request.Options.ForceHTTP1,
-	}
-
-	client, err := newClient(
-		browser,
-		request.Options.Timeout,
-		request.Options.DisableRedirect,
-		request.Options.UserAgent,
-		request.Options.Proxy,
-	)
+func processRequest(request cycleTLSRequest) (result fullRequest, err error) {
+	client, err := getClient(request.Options)
 	if err != nil {
-		log.Fatal(err)
+		return result, err
 	}
 
 	req, err := http.NewRequest(strings.ToUpper(request.Options.Method), request.Options.URL, strings.NewReader(request.Options.Body))
 	if err != nil {
-		log.Fatal(err)
+		return result, err
 	}
 	headerorder := []string{}
 	//master header order, all your headers will be ordered based on this list and anything extra will be appended to the end
@@ -151,7 +142,7 @@ func processRequest(request cycleTLSRequest) (result fullRequest) {
 		}
 
 	}
-	headerOrder := parseUserAgent(request.Options.UserAgent).HeaderOrder
+	headerOrder := parseClientType(request.Options.ClientType, request.Options.UserAgent).HeaderOrder
 
 	//ordering the pseudo headers and our normal headers
 	req.Header = http.Header{
@@ -161,7 +152,7 @@ func processRequest(request cycleTLSRequest) (result fullRequest) {
 	//set our Host header
 	u, err := url.Parse(request.Options.URL)
 	if err != nil {
-		panic(err)
+		return result, err
 	}
 
 	//append our normal headers
@@ -172,14 +163,27 @@ func processRequest(request cycleTLSRequest) (result fullRequest) {
 	}
 	req.Header.Set("Host", u.Host)
 	req.Header.Set("user-agent", request.Options.UserAgent)
-	return fullRequest{req: req, client: client, options: request}
+	// 客户端在请求间共享, cookie 随请求设置
+	for _, cookie := range request.Options.Cookies {
+		req.AddCookie(cookie.toHTTPCookie())
+	}
+	return fullRequest{req: req, client: client, options: request}, nil
 
 }
 
+// requestTimeout 整个请求(含读取响应体)的超时, 未设置时默认15秒
+func requestTimeout(options Options) time.Duration {
+	if options.Timeout == 0 {
+		return 15 * time.Second
+	}
+	return time.Duration(options.Timeout) * time.Second
+}
+
 func dispatcher(res fullRequest) (response Response, err error) {
-	defer res.client.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout(res.options.Options))
+	defer cancel()
 	finalUrl := res.options.Options.URL
-	resp, err := res.client.Do(res.req)
+	resp, err := res.client.Do(res.req.WithContext(ctx))
 	if err != nil {
 
 		parsedError := parseError(err)
@@ -234,7 +238,11 @@ func (client CycleTLS) Queue(URL string, options Options, Method string) {
 	options.Method = Method
 	//TODO add timestamp to request
 	opt := cycleTLSRequest{"Queued Request", options}
-	response := processRequest(opt)
+	response, err := processRequest(opt)
+	if err != nil {
+		log.Print("Request Failed: " + err.Error())
+		return
+	}
 	client.ReqChan <- response
 }
 
@@ -253,7 +261,10 @@ func (client CycleTLS) Do(URL string, options Options, Method string) (response
 	}
 	opt := cycleTLSRequest{"cycleTLSRequest", options}
 
-	res := processRequest(opt)
+	res, err := processRequest(opt)
+	if err != nil {
+		return response, err
+	}
 	response, err = dispatcher(res)
 	if err != nil {
 		return response, err
@@ -317,7 +328,11 @@ func readSocket(reqChan chan fullRequest, c *websocket.Conn) {
 			return
 		}
 
-		reply := processRequest(*request)
+		reply, err := processRequest(*request)
+		if err != nil {
+			log.Print("Request Failed: " + err.Error())
+			continue
+		}
 
 		reqChan <- reply
 	}
@@ -408,6 +423,9 @@ func main() {
 	log.Fatal(nhttp.ListenAndServe(*addr, nil))
 }
 
+// ConnectTimeoutError 超过 ConnectTimeout 仍未收到响应头时返回的内容
+const ConnectTimeoutError = `{"error":"Upstream timeout","message":"No response from upstream within the connect timeout."}`
+
 // 修改 SSEResponse 结构体，添加 FinalUrl 字段
 type SSEResponse struct {
 	RequestID string
@@ -417,72 +435,113 @@ type SSEResponse struct {
 	FinalUrl  string // 添加 FinalUrl 字段
 }
 
-func dispatcherSSE(res fullRequest, sseChan chan<- SSEResponse) {
-	defer res.client.CloseIdleConnections()
-
+func dispatcherSSE(ctx context.Context, res fullRequest, sseChan chan<- SSEResponse) {
 	finalUrl := res.options.Options.URL
 
-	resp, err := res.client.Do(res.req)
+	// 请求上下文结束或超时时中断连接和响应体读取, 连接本身归还连接池
+	reqCtx, cancel := context.WithTimeout(ctx, requestTimeout(res.options.Options))
+	defer cancel()
+	var connectTimer *time.Timer
+	var connectTimedOut atomic.Bool
+	if res.options.Options.ConnectTimeout > 0 {
+		connectTimer = time.AfterFunc(time.Duration(res.options.Options.ConnectTimeout)*time.Second, func() {
+			connectTimedOut.Store(true)
+			cancel()
+		})
+	}
+
+	resp, err := res.client.Do(res.req.WithContext(reqCtx))
+	// 已收到响应头, 连接超时不再生效
+	if connectTimer != nil && !connectTimer.Stop() && connectTimedOut.Load() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		sendSSE(ctx, sseChan, SSEResponse{
+			RequestID: res.options.RequestID,
+			Status:    408,
+			Data:      ConnectTimeoutError,
+			Done:      true,
+			FinalUrl:  finalUrl,
+		})
+		return
+	}
 	if err != nil {
 		parsedError := parseError(err)
-		sseChan <- SSEResponse{
+		sendSSE(ctx, sseChan, SSEResponse{
 			RequestID: res.options.RequestID,
 			Status:    parsedError.StatusCode,
 			Data:      fmt.Sprintf("%s-> \n%s", parsedError.ErrorMsg, err.Error()),
 			Done:      true,
 			FinalUrl:  finalUrl,
-		}
+		})
 		return
 	}
 	defer resp.Body.Close()
 
+	// 更新最终URL（考虑重定向）
+	if resp.Request != nil && resp.Request.URL != nil {
+		finalUrl = resp.Request.URL.String()
+	}
+
+	ReadSSE(ctx, resp.Body, resp.StatusCode, res.options.RequestID, finalUrl, sseChan)
+}
+
+// sendSSE 发送帧, 接收方已不再读取(ctx 结束)时放弃发送并返回 false
+func sendSSE(ctx context.Context, sseChan chan<- SSEResponse, response SSEResponse) bool {
+	select {
+	case sseChan <- response:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ScanSections 以›作为分隔符的 bufio.SplitFunc
+func ScanSections(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	separator := []byte("›")
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	// 查找›分隔符
+	if i := bytes.Index(data, separator); i >= 0 {
+		// 发现分隔符，返回分隔符之前的数据
+		return i + len(separator), data[0:i], nil
+	}
+
+	// 如果到达文件末尾，返回剩余的所有数据
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	// 请求更多数据
+	return 0, nil, nil
+}
+
+// ReadSSE 读取上游响应体并逐帧写入 sseChan, 供不同的传输实现复用
+func ReadSSE(ctx context.Context, body io.Reader, status int, requestID, finalUrl string, sseChan chan<- SSEResponse) {
 	// 检查HTTP状态码，非2xx状态码可能表示错误
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
+	if status < 200 || status >= 300 {
+		bodyBytes, _ := io.ReadAll(body)
 		errorMsg := string(bodyBytes)
 		if errorMsg == "" {
-			errorMsg = fmt.Sprintf("HTTP error status: %d", resp.StatusCode)
+			errorMsg = fmt.Sprintf("HTTP error status: %d", status)
 		}
 
-		sseChan <- SSEResponse{
-			RequestID: res.options.RequestID,
-			Status:    resp.StatusCode,
+		sendSSE(ctx, sseChan, SSEResponse{
+			RequestID: requestID,
+			Status:    status,
 			Data:      errorMsg,
 			Done:      true,
 			FinalUrl:  finalUrl,
-		}
+		})
 		return
 	}
 
-	// 更新最终URL（考虑重定向）
-	if resp.Request != nil && resp.Request.URL != nil {
-		finalUrl = resp.Request.URL.String()
-	}
-
 	// 使用bufio.Scanner来处理流式数据
-	scanner := bufio.NewScanner(resp.Body)
-
-	// 自定义分隔符函数，以›作为分隔符
-	separator := []byte("›")
-	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
-
-		// 查找›分隔符
-		if i := bytes.Index(data, separator); i >= 0 {
-			// 发现分隔符，返回分隔符之前的数据
-			return i + len(separator), data[0:i], nil
-		}
-
-		// 如果到达文件末尾，返回剩余的所有数据
-		if atEOF {
-			return len(data), data, nil
-		}
-
-		// 请求更多数据
-		return 0, nil, nil
-	})
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	scanner.Split(ScanSections)
 
 	// 读取并处理流式数据
 	for scanner.Scan() {
@@ -492,41 +551,41 @@ func dispatcherSSE(res fullRequest, sseChan chan<- SSEResponse) {
 		}
 
 		// 发送数据给客户端
-		sseChan <- SSEResponse{
-			RequestID: res.options.RequestID,
-			Status:    resp.StatusCode,
+		if !sendSSE(ctx, sseChan, SSEResponse{
+			RequestID: requestID,
+			Status:    status,
 			Data:      data,
 			Done:      false,
 			FinalUrl:  finalUrl,
+		}) {
+			return
 		}
 	}
 
 	// 检查扫描过程中是否有错误
 	if err := scanner.Err(); err != nil {
-		sseChan <- SSEResponse{
-			RequestID: res.options.RequestID,
-			Status:    resp.StatusCode,
+		sendSSE(ctx, sseChan, SSEResponse{
+			RequestID: requestID,
+			Status:    status,
 			Data:      "Error reading stream: " + err.Error(),
 			Done:      true,
 			FinalUrl:  finalUrl,
-		}
+		})
 		return
 	}
 
 	// 发送完成信号
-	sseChan <- SSEResponse{
-		RequestID: res.options.RequestID,
-		Status:    resp.StatusCode,
+	sendSSE(ctx, sseChan, SSEResponse{
+		RequestID: requestID,
+		Status:    status,
 		Data:      "[DONE]",
 		Done:      true,
 		FinalUrl:  finalUrl,
-	}
+	})
 }
 
-// 修改 Do 方法以支持 SSE
-func (client CycleTLS) DoSSE(URL string, options Options, Method string) (<-chan SSEResponse, error) {
-	sseChan := make(chan SSEResponse)
-
+// 修改 Do 方法以支持 SSE, ctx 结束时关闭上游连接并退出读取协程
+func (client CycleTLS) DoSSE(ctx context.Context, URL string, options Options, Method string) (<-chan SSEResponse, error) {
 	options.URL = URL
 	options.Method = Method
 	if options.Ja3 == "" {
@@ -537,11 +596,15 @@ func (client CycleTLS) DoSSE(URL string, options Options, Method string) (<-chan
 	}
 
 	opt := cycleTLSRequest{"cycleTLSRequest", options}
-	res := processRequest(opt)
+	res, err := processRequest(opt)
+	if err != nil {
+		return nil, err
+	}
 
+	sseChan := make(chan SSEResponse)
 	go func() {
 		defer close(sseChan)
-		dispatcherSSE(res, sseChan)
+		dispatcherSSE(ctx, res, sseChan)
 	}()
 
 	return sseChan, nil
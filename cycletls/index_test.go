@@ -0,0 +1,53 @@
+package cycletls
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestDoSSECancelNoLeak 取消请求后读取协程和上游连接都应退出, 无论调用方是否继续读取通道
+func TestDoSSECancelNoLeak(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"n":1}›`)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := CycleTLS{}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, err := client.DoSSE(ctx, server.URL, Options{Timeout: 30}, http.MethodPost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame := <-ch; frame.Data != `{"n":1}` {
+			t.Fatalf("unexpected frame %+v", frame)
+		}
+		cancel()
+		if i%2 == 0 {
+			// 一半的请求在取消后继续读到通道关闭, 另一半直接放弃读取
+			for range ch {
+			}
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		after := runtime.NumGoroutine()
+		if after <= before {
+			return
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("goroutines leaked: before %d, after %d\n%s", before, after, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
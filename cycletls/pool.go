@@ -0,0 +1,138 @@
+package cycletls
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	http "github.com/Danny-Dasilva/fhttp"
+)
+
+// clientKey 决定连接能否复用: 代理与 TLS 指纹相同的请求共享同一个客户端
+type clientKey struct {
+	JA3                string
+	UserAgent          string
+	ClientType         string
+	Proxy              string
+	InsecureSkipVerify bool
+	ForceHTTP1         bool
+	DisableRedirect    bool
+}
+
+var (
+	// MaxPooledClients 连接池最多保留的客户端数, 超出时淘汰最久未使用的客户端
+	MaxPooledClients = 64
+	// PooledClientIdleTimeout 客户端超过该时长未被使用时从连接池移除
+	PooledClientIdleTimeout = 10 * time.Minute
+)
+
+type pooledClient struct {
+	key      clientKey
+	client   *http.Client
+	lastUsed time.Time
+}
+
+// clientPool 长期存活的客户端, 复用底层 TCP/TLS 及 HTTP/2 连接
+// 键包含代理与指纹, 按最近使用顺序淘汰, 避免轮换代理时无限增长
+var clientPool = struct {
+	sync.Mutex
+	entries map[clientKey]*list.Element // -> *pooledClient
+	lru     *list.List                  // 表头为最近使用
+}{entries: map[clientKey]*list.Element{}, lru: list.New()}
+
+// getClient 从连接池获取客户端, 不存在时创建
+// 池中客户端不设置 Timeout, 超时由每个请求的 context 控制
+func getClient(options Options) (*http.Client, error) {
+	key := clientKey{
+		JA3:                options.Ja3,
+		UserAgent:          options.UserAgent,
+		ClientType:         options.ClientType,
+		Proxy:              options.Proxy,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+		ForceHTTP1:         options.ForceHTTP1,
+		DisableRedirect:    options.DisableRedirect,
+	}
+	if client := loadClient(key); client != nil {
+		return client, nil
+	}
+
+	browser := Browser{
+		JA3:                options.Ja3,
+		UserAgent:          options.UserAgent,
+		ClientType:         options.ClientType,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+		forceHTTP1:         options.ForceHTTP1,
+	}
+	client, err := newClient(browser, 0, options.DisableRedirect, options.UserAgent, options.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = 0
+	return storeClient(key, &client), nil
+}
+
+// loadClient 命中时刷新使用时间
+func loadClient(key clientKey) *http.Client {
+	clientPool.Lock()
+	defer clientPool.Unlock()
+	elem, ok := clientPool.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*pooledClient)
+	entry.lastUsed = time.Now()
+	clientPool.lru.MoveToFront(elem)
+	return entry.client
+}
+
+// storeClient 存入新建的客户端, 并发创建时保留先存入的一个
+func storeClient(key clientKey, client *http.Client) *http.Client {
+	clientPool.Lock()
+	if elem, ok := clientPool.entries[key]; ok {
+		entry := elem.Value.(*pooledClient)
+		entry.lastUsed = time.Now()
+		clientPool.lru.MoveToFront(elem)
+		clientPool.Unlock()
+		client.CloseIdleConnections()
+		return entry.client
+	}
+	clientPool.entries[key] = clientPool.lru.PushFront(&pooledClient{key: key, client: client, lastUsed: time.Now()})
+	evicted := evictClients()
+	clientPool.Unlock()
+
+	// 被淘汰客户端上进行中的请求不受影响, 只关闭空闲连接
+	for _, c := range evicted {
+		c.CloseIdleConnections()
+	}
+	return client
+}
+
+// evictClients 移除超出数量上限或空闲超时的客户端, 调用方需持有锁
+func evictClients() []*http.Client {
+	var evicted []*http.Client
+	for elem := clientPool.lru.Back(); elem != nil; elem = clientPool.lru.Back() {
+		entry := elem.Value.(*pooledClient)
+		if clientPool.lru.Len() <= MaxPooledClients && time.Since(entry.lastUsed) < PooledClientIdleTimeout {
+			break
+		}
+		clientPool.lru.Remove(elem)
+		delete(clientPool.entries, entry.key)
+		evicted = append(evicted, entry.client)
+	}
+	return evicted
+}
+
+// CloseIdleConnections 关闭连接池中所有客户端的空闲连接, 并移除空闲超时的客户端
+func CloseIdleConnections() {
+	clientPool.Lock()
+	evicted := evictClients()
+	clients := make([]*http.Client, 0, clientPool.lru.Len())
+	for elem := clientPool.lru.Front(); elem != nil; elem = elem.Next() {
+		clients = append(clients, elem.Value.(*pooledClient).client)
+	}
+	clientPool.Unlock()
+
+	for _, client := range append(clients, evicted...) {
+		client.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,117 @@
+package cycletls
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	nhttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	http "github.com/Danny-Dasilva/fhttp"
+)
+
+func resetClientPool() {
+	clientPool.Lock()
+	defer clientPool.Unlock()
+	clientPool.entries = map[clientKey]*list.Element{}
+	clientPool.lru.Init()
+}
+
+func TestClientPoolEviction(t *testing.T) {
+	resetClientPool()
+	defer resetClientPool()
+	oldMax, oldIdle := MaxPooledClients, PooledClientIdleTimeout
+	defer func() { MaxPooledClients, PooledClientIdleTimeout = oldMax, oldIdle }()
+	MaxPooledClients = 2
+
+	get := func(proxy string) *http.Client {
+		t.Helper()
+		client, err := getClient(Options{Proxy: proxy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	a := get("http://127.0.0.1:1")
+	get("http://127.0.0.1:2")
+	if get("http://127.0.0.1:1") != a {
+		t.Fatal("expected pooled client to be reused")
+	}
+	// 1 刚被使用, 超出上限时淘汰 2
+	get("http://127.0.0.1:3")
+	if _, ok := clientPool.entries[clientKey{Proxy: "http://127.0.0.1:2"}]; ok {
+		t.Error("least recently used client was not evicted")
+	}
+	if len(clientPool.entries) != 2 || clientPool.lru.Len() != 2 {
+		t.Errorf("pool size %d/%d, want 2", len(clientPool.entries), clientPool.lru.Len())
+	}
+
+	PooledClientIdleTimeout = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	get("http://127.0.0.1:4")
+	if len(clientPool.entries) != 1 {
+		t.Errorf("idle clients were not evicted, pool size %d", len(clientPool.entries))
+	}
+}
+
+func newBenchServer(b *testing.B) *httptest.Server {
+	server := httptest.NewUnstartedServer(nhttp.HandlerFunc(func(w nhttp.ResponseWriter, r *nhttp.Request) {
+		fmt.Fprint(w, `{"n":1}›`)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	b.Cleanup(server.Close)
+	return server
+}
+
+func benchSSE(b *testing.B, server *httptest.Server, before func()) {
+	client := CycleTLS{}
+	options := Options{InsecureSkipVerify: true, Timeout: 10}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if before != nil {
+			before()
+		}
+		ch, err := client.DoSSE(context.Background(), server.URL, options, nhttp.MethodPost)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for frame := range ch {
+			if frame.Status != 200 {
+				b.Fatalf("unexpected frame %+v", frame)
+			}
+		}
+	}
+}
+
+// BenchmarkDoSSEPooled 复用连接池中的客户端及其 HTTP/2 连接
+func BenchmarkDoSSEPooled(b *testing.B) {
+	resetClientPool()
+	benchSSE(b, newBenchServer(b), nil)
+}
+
+// BenchmarkDoSSEFreshClient 每次请求新建客户端, 即引入连接池前的行为, 每次都需要重新握手
+func BenchmarkDoSSEFreshClient(b *testing.B) {
+	benchSSE(b, newBenchServer(b), func() {
+		CloseIdleConnections()
+		resetClientPool()
+	})
+}
+
+func BenchmarkGetClientHit(b *testing.B) {
+	resetClientPool()
+	options := Options{Ja3: "771,4865-4866-4867,0-23,29,0", UserAgent: "bench"}
+	if _, err := getClient(options); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := getClient(options); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -20,8 +20,9 @@ var errProtocolNegotiated = errors.New("protocol negotiated")
 type roundTripper struct {
 	sync.Mutex
 	// fix typing
-	JA3       string
-	UserAgent string
+	JA3        string
+	UserAgent  string
+	ClientType string
 
 	InsecureSkipVerify bool
 	Cookies            []Cookie
@@ -35,62 +36,62 @@ type roundTripper struct {
 func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	// Fix this later for proper cookie parsing
 	for _, properties := range rt.Cookies {
-		req.AddCookie(&http.Cookie{
-			Name:       properties.Name,
-			Value:      properties.Value,
-			Path:       properties.Path,
-			Domain:     properties.Domain,
-			Expires:    properties.JSONExpires.Time, //TODO: scuffed af
-			RawExpires: properties.RawExpires,
-			MaxAge:     properties.MaxAge,
-			HttpOnly:   properties.HTTPOnly,
-			Secure:     properties.Secure,
-			Raw:        properties.Raw,
-			Unparsed:   properties.Unparsed,
-		})
+		req.AddCookie(properties.toHTTPCookie())
 	}
 	req.Header.Set("User-Agent", rt.UserAgent)
 	addr := rt.getDialTLSAddr(req)
-	if _, ok := rt.cachedTransports[addr]; !ok {
-		if err := rt.getTransport(req, addr); err != nil {
-			return nil, err
-		}
+	transport, err := rt.getTransport(req, addr)
+	if err != nil {
+		return nil, err
 	}
-	return rt.cachedTransports[addr].RoundTrip(req)
+	return transport.RoundTrip(req)
 }
 
-func (rt *roundTripper) getTransport(req *http.Request, addr string) error {
-	switch strings.ToLower(req.URL.Scheme) {
-	case "http":
-		rt.cachedTransports[addr] = &http.Transport{DialContext: rt.dialer.DialContext, DisableKeepAlives: true}
-		return nil
-	case "https":
-	default:
-		return fmt.Errorf("invalid URL scheme: [%v]", req.URL.Scheme)
+// getTransport 返回 addr 对应的 transport, 不存在时通过一次 TLS 握手的 ALPN 结果确定
+// roundTripper 在并发请求间共享, cachedTransports/cachedConnections 只能在锁内访问
+func (rt *roundTripper) getTransport(req *http.Request, addr string) (http.RoundTripper, error) {
+	rt.Lock()
+	transport := rt.cachedTransports[addr]
+	if transport == nil && strings.ToLower(req.URL.Scheme) == "http" {
+		transport = &http.Transport{DialContext: rt.dialer.DialContext}
+		rt.cachedTransports[addr] = transport
+	}
+	rt.Unlock()
+	if transport != nil {
+		return transport, nil
 	}
 
-	_, err := rt.dialTLS(req.Context(), "tcp", addr)
+	if strings.ToLower(req.URL.Scheme) != "https" {
+		return nil, fmt.Errorf("invalid URL scheme: [%v]", req.URL.Scheme)
+	}
+
+	conn, err := rt.dialTLS(req.Context(), "tcp", addr)
 	switch err {
 	case errProtocolNegotiated:
 	case nil:
-		// Should never happen.
-		panic("dialTLS returned no error when determining cachedTransports")
+		// 并发请求已确定了 transport, 多余的连接直接关闭
+		_ = conn.Close()
 	default:
-		return err
+		return nil, err
 	}
 
-	return nil
-}
-
-func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
 	rt.Lock()
 	defer rt.Unlock()
+	return rt.cachedTransports[addr], nil
+}
 
+func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
 	// If we have the connection from when we determined the HTTPS
 	// cachedTransports to use, return that.
+	// 暂存的连接只能使用一次, 取出后即删除
+	rt.Lock()
 	if conn := rt.cachedConnections[addr]; conn != nil {
+		delete(rt.cachedConnections, addr)
+		rt.Unlock()
 		return conn, nil
 	}
+	rt.Unlock()
+
 	rawConn, err := rt.dialer.DialContext(ctx, network, addr)
 	if err != nil {
 		return nil, err
@@ -102,8 +103,9 @@ func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.
 	}
 	//////////////////
 
-	spec, err := StringToSpec(rt.JA3, rt.UserAgent, rt.forceHTTP1)
+	spec, err := StringToSpec(rt.JA3, rt.UserAgent, rt.ClientType, rt.forceHTTP1)
 	if err != nil {
+		_ = rawConn.Close()
 		return nil, err
 	}
 
@@ -113,6 +115,7 @@ func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.
 		utls.HelloCustom)
 
 	if err := conn.ApplyPreset(spec); err != nil {
+		_ = rawConn.Close()
 		return nil, err
 	}
 
@@ -126,6 +129,8 @@ func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.
 		return nil, fmt.Errorf("uTlsConn.Handshake() error: %+v", err)
 	}
 
+	rt.Lock()
+	defer rt.Unlock()
 	if rt.cachedTransports[addr] != nil {
 		return conn, nil
 	}
@@ -134,17 +139,27 @@ func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.
 	// of ALPN.
 	switch conn.ConnectionState().NegotiatedProtocol {
 	case http2.NextProtoTLS:
-		parsedUserAgent := parseUserAgent(rt.UserAgent)
-
-		t2 := http2.Transport{
-			DialTLS:     rt.dialTLSHTTP2,
-			PushHandler: &http2.DefaultPushHandler{},
-			Navigator:   parsedUserAgent.UserAgent,
+		parsedUserAgent := parseClientType(rt.ClientType, rt.UserAgent)
+
+		var t2 http2.Transport
+		if parsedUserAgent.UserAgent == safari {
+			// fhttp 没有 Safari 预设, 按 CFNetwork 的 SETTINGS 设置且不启用推送
+			t2 = http2.Transport{
+				DialTLS:           rt.dialTLSHTTP2,
+				Settings:          []http2.Setting{{ID: http2.SettingMaxConcurrentStreams, Val: 100}},
+				InitialWindowSize: 4194304,
+			}
+		} else {
+			t2 = http2.Transport{
+				DialTLS:     rt.dialTLSHTTP2,
+				PushHandler: &http2.DefaultPushHandler{},
+				Navigator:   parsedUserAgent.UserAgent,
+			}
 		}
 		rt.cachedTransports[addr] = &t2
 	default:
 		// Assume the remote peer is speaking HTTP 1.x + TLS.
-		rt.cachedTransports[addr] = &http.Transport{DialTLSContext: rt.dialTLS, DisableKeepAlives: true}
+		rt.cachedTransports[addr] = &http.Transport{DialTLSContext: rt.dialTLS}
 
 	}
 
@@ -168,10 +183,17 @@ func (rt *roundTripper) getDialTLSAddr(req *http.Request) string {
 }
 
 func (rt *roundTripper) CloseIdleConnections() {
+	rt.Lock()
+	defer rt.Unlock()
 	for addr, conn := range rt.cachedConnections {
 		_ = conn.Close()
 		delete(rt.cachedConnections, addr)
 	}
+	for _, transport := range rt.cachedTransports {
+		if t, ok := transport.(interface{ CloseIdleConnections() }); ok {
+			t.CloseIdleConnections()
+		}
+	}
 }
 
 func newRoundTripper(browser Browser, dialer ...proxy.ContextDialer) http.RoundTripper {
@@ -181,6 +203,7 @@ func newRoundTripper(browser Browser, dialer ...proxy.ContextDialer) http.RoundT
 			dialer:             dialer[0],
 			JA3:                browser.JA3,
 			UserAgent:          browser.UserAgent,
+			ClientType:         browser.ClientType,
 			Cookies:            browser.Cookies,
 			cachedTransports:   make(map[string]http.RoundTripper),
 			cachedConnections:  make(map[string]net.Conn),
@@ -193,6 +216,7 @@ func newRoundTripper(browser Browser, dialer ...proxy.ContextDialer) http.RoundT
 		dialer:             proxy.Direct,
 		JA3:                browser.JA3,
 		UserAgent:          browser.UserAgent,
+		ClientType:         browser.ClientType,
 		Cookies:            browser.Cookies,
 		cachedTransports:   make(map[string]http.RoundTripper),
 		cachedConnections:  make(map[string]net.Conn),
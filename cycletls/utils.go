@@ -18,6 +18,14 @@ import (
 const (
 	chrome  = "chrome"  //chrome User agent enum
 	firefox = "firefox" //firefox User agent enum
+	safari  = "safari"  //safari User agent enum
+)
+
+// 客户端类型, 决定 GREASE、HTTP/2 伪头部顺序及 SETTINGS, 未指定时按 User-Agent 推断
+const (
+	ClientChrome  = chrome
+	ClientFirefox = firefox
+	ClientSafari  = safari // Safari 及使用 CFNetwork 的 Apple 原生应用
 )
 
 type UserAgent struct {
@@ -38,6 +46,20 @@ func parseUserAgent(userAgent string) UserAgent {
 
 }
 
+// parseClientType 优先使用显式指定的客户端类型, 未指定或无法识别时按 User-Agent 推断
+func parseClientType(clientType, userAgent string) UserAgent {
+	switch clientType {
+	case chrome:
+		return UserAgent{chrome, []string{":method", ":authority", ":scheme", ":path"}}
+	case firefox:
+		return UserAgent{firefox, []string{":method", ":path", ":authority", ":scheme"}}
+	case safari:
+		return UserAgent{safari, []string{":method", ":scheme", ":path", ":authority"}}
+	default:
+		return parseUserAgent(userAgent)
+	}
+}
+
 // DecompressBody unzips compressed data
 func DecompressBody(Body []byte, encoding []string, content []string) (parsedBody string) {
 	if len(encoding) > 0 {
@@ -104,8 +126,9 @@ func unBrotliData(data []byte) (resData []byte, err error) {
 }
 
 // StringToSpec creates a ClientHelloSpec based on a JA3 string
-func StringToSpec(ja3 string, userAgent string, forceHTTP1 bool) (*utls.ClientHelloSpec, error) {
-	parsedUserAgent := parseUserAgent(userAgent)
+// clientType 为空时按 userAgent 推断客户端类型
+func StringToSpec(ja3 string, userAgent string, clientType string, forceHTTP1 bool) (*utls.ClientHelloSpec, error) {
+	parsedUserAgent := parseClientType(clientType, userAgent)
 	// if tlsExtensions == nil {
 	// 	tlsExtensions = &TLSExtensions{}
 	// }
@@ -139,7 +162,7 @@ func StringToSpec(ja3 string, userAgent string, forceHTTP1 bool) (*utls.ClientHe
 				keyShare.KeyShares = append([]utls.KeyShare{{Group: utls.CurveID(utls.GREASE_PLACEHOLDER), Data: []byte{0}}}, keyShare.KeyShares...)
 			}
 		}
-	} else {
+	} else if parsedUserAgent.UserAgent == firefox {
 		if keyShareExt, ok := extMap["51"]; ok {
 			if keyShare, ok := keyShareExt.(*utls.KeyShareExtension); ok {
 				keyShare.KeyShares = append(keyShare.KeyShares, utls.KeyShare{Group: utls.CurveP256})
@@ -0,0 +1,69 @@
+package cycletls
+
+import (
+	"reflect"
+	"testing"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// CFNetwork 客户端的 JA3
+const testSafariJA3 = "771,4865-4866-4867-49196-49195-52393-49200-49199-52392-49162-49161-49172-49171-157-156-53-47-49160-49170-10,0-23-65281-10-11-16-5-13-18-51-45-43-27-21,29-23-24-25,0"
+
+const testCFNetworkUA = "AlexSideBar/190 CFNetwork/1568.300.101 Darwin/24.2.0"
+
+func hasGREASE(spec *utls.ClientHelloSpec) bool {
+	if len(spec.CipherSuites) > 0 && spec.CipherSuites[0] == utls.GREASE_PLACEHOLDER {
+		return true
+	}
+	for _, ext := range spec.Extensions {
+		switch e := ext.(type) {
+		case *utls.UtlsGREASEExtension:
+			return true
+		case *utls.SupportedCurvesExtension:
+			for _, curve := range e.Curves {
+				if curve == utls.CurveID(utls.GREASE_PLACEHOLDER) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func TestStringToSpecClientType(t *testing.T) {
+	tests := []struct {
+		clientType string
+		grease     bool
+	}{
+		{ClientSafari, false},
+		{ClientChrome, true},
+		// 未指定时按 User-Agent 推断, 非浏览器 UA 沿用 chrome
+		{"", true},
+	}
+	for _, tt := range tests {
+		spec, err := StringToSpec(testSafariJA3, testCFNetworkUA, tt.clientType, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hasGREASE(spec); got != tt.grease {
+			t.Errorf("client type %q: GREASE %v, want %v", tt.clientType, got, tt.grease)
+		}
+	}
+}
+
+func TestParseClientTypeHeaderOrder(t *testing.T) {
+	tests := []struct {
+		clientType, userAgent string
+		want                  []string
+	}{
+		{ClientSafari, testCFNetworkUA, []string{":method", ":scheme", ":path", ":authority"}},
+		{ClientChrome, testCFNetworkUA, []string{":method", ":authority", ":scheme", ":path"}},
+		{"", "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0", []string{":method", ":path", ":authority", ":scheme"}},
+	}
+	for _, tt := range tests {
+		if got := parseClientType(tt.clientType, tt.userAgent).HeaderOrder; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("client type %q: header order %v, want %v", tt.clientType, got, tt.want)
+		}
+	}
+}
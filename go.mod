@@ -5,7 +5,6 @@ go 1.23.7
 require (
 	github.com/Danny-Dasilva/fhttp v0.0.0-20240217042913-eeeb0b347ce1
 	github.com/andybalholm/brotli v1.1.1
-	github.com/deanxv/CycleTLS/cycletls v0.0.0-20250329015524-d329c565ce79
 	github.com/gin-contrib/cors v1.7.4
 	github.com/gin-contrib/gzip v1.2.2
 	github.com/gin-contrib/static v1.1.3
@@ -1,6 +1,7 @@
 package google_api
 
 import (
+	"alexsidebar2api/common/env"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -29,10 +30,13 @@ type TokenResponse struct {
 
 var key = "QUl6YVN5Qi1ucUE1ajczN2w1TmQzOUs2ZkJpdDc2VklyeW1xT1Vn"
 
+// TokenURL is the Firebase secure token endpoint, overridable for mock upstreams
+var TokenURL = env.String("GOOGLE_TOKEN_URL", "https://securetoken.googleapis.com/v1/token")
+
 // GetFirebaseToken refreshes a Firebase token using the refresh token
 func GetFirebaseToken(req RefreshTokenRequest) (*TokenResponse, error) {
 	// Prepare request
-	apiURL := "https://securetoken.googleapis.com/v1/token"
+	apiURL := TokenURL
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
 	data.Set("refresh_token", req.RefreshToken)
@@ -5,13 +5,10 @@ import (
 	logger "alexsidebar2api/common/loggger"
 	google_api "alexsidebar2api/google-api"
 	"fmt"
-	"github.com/deanxv/CycleTLS/cycletls"
 	"time"
 )
 
 func UpdateCookieTokenTask() {
-	client := cycletls.Init()
-	defer safeClose(client)
 	for {
 		logger.SysLog("alexsidebar2api Scheduled UpdateCookieTokenTask Task Job Start!")
 
@@ -48,11 +45,3 @@ func UpdateCookieTokenTask() {
 		time.Sleep(next.Sub(now))
 	}
 }
-func safeClose(client cycletls.CycleTLS) {
-	if client.ReqChan != nil {
-		close(client.ReqChan)
-	}
-	if client.RespChan != nil {
-		close(client.RespChan)
-	}
-}
@@ -9,8 +9,10 @@ import (
 	"alexsidebar2api/common"
 	"alexsidebar2api/common/config"
 	logger "alexsidebar2api/common/loggger"
+	google_api "alexsidebar2api/google-api"
 	"alexsidebar2api/job"
 	"alexsidebar2api/middleware"
+	mock_api "alexsidebar2api/mock-api"
 	"alexsidebar2api/model"
 	"alexsidebar2api/router"
 	"fmt"
@@ -23,6 +25,7 @@ import (
 //var buildFS embed.FS
 
 func main() {
+	common.Init()
 	logger.SetupLogger()
 	logger.SysLog(fmt.Sprintf("alexsidebar2api %s starting...", common.Version))
 
@@ -35,6 +38,29 @@ func main() {
 	var err error
 
 	model.InitTokenEncoders()
+
+	if config.MockUpstreamEnabled {
+		if err = mock_api.Start(config.MockUpstreamPort); err != nil {
+			logger.FatalLog(err)
+		}
+		// 未显式配置上游地址时指向模拟上游
+		if os.Getenv("AS_BASE_URL") == "" {
+			config.ASBaseURL = mock_api.BaseURL(config.MockUpstreamPort)
+		}
+		if os.Getenv("GOOGLE_TOKEN_URL") == "" {
+			google_api.TokenURL = mock_api.BaseURL(config.MockUpstreamPort) + "/v1/token"
+		}
+		logger.SysLog("running with mock upstream.")
+	}
+
+	if err = config.InitFingerprints(); err != nil {
+		logger.FatalLog(err)
+	}
+
+	if err = config.InitPromptTemplates(); err != nil {
+		logger.FatalLog(err)
+	}
+
 	_, err = config.InitASCookies()
 	if err != nil {
 		logger.FatalLog(err)
@@ -3,6 +3,7 @@ package middleware
 import (
 	"alexsidebar2api/common"
 	"alexsidebar2api/common/config"
+	"alexsidebar2api/common/helper"
 	logger "alexsidebar2api/common/loggger"
 	"alexsidebar2api/model"
 	"github.com/gin-gonic/gin"
@@ -11,12 +12,18 @@ import (
 	"strings"
 )
 
-func isValidSecret(secret string) bool {
+// lookupApiKey 校验密钥, 环境变量中的密钥不受模型限制, 可使用全部账号
+func lookupApiKey(secret string) (config.ApiKey, bool) {
+	if apiKey, ok := config.GetApiKey(secret); ok {
+		return apiKey, true
+	}
 	if config.ApiSecret == "" {
-		return true
-	} else {
-		return lo.Contains(config.ApiSecrets, secret)
+		return config.ApiKey{AccountGroup: config.AllAccountsGroup}, !config.HasApiKeys()
+	}
+	if lo.Contains(config.ApiSecrets, secret) {
+		return config.ApiKey{Key: secret, AccountGroup: config.AllAccountsGroup}, true
 	}
+	return config.ApiKey{}, false
 }
 
 func isValidBackendSecret(secret string) bool {
@@ -27,20 +34,29 @@ func authHelperForOpenai(c *gin.Context) {
 	secret := c.Request.Header.Get("Authorization")
 	secret = strings.Replace(secret, "Bearer ", "", 1)
 
-	b := isValidSecret(secret)
+	apiKey, b := lookupApiKey(secret)
 
 	if !b {
-		c.JSON(http.StatusUnauthorized, model.OpenAIErrorResponse{
-			OpenAIError: model.OpenAIError{
-				Message: "API-KEY校验失败",
-				Type:    "invalid_request_error",
-				Code:    "invalid_authorization",
-			},
-		})
-		c.Abort()
+		model.AbortWithOpenAIError(c, model.NewAPIError(http.StatusUnauthorized, "invalid_authorization", "API-KEY校验失败"))
 		return
 	}
 
+	if apiKey.IsExpired() {
+		model.AbortWithOpenAIError(c, model.NewAPIError(http.StatusUnauthorized, "expired_authorization", "API-KEY已过期"))
+		return
+	}
+
+	if apiKey.RateLimit > 0 {
+		inMemoryRateLimiter.Init(config.RateLimitKeyExpirationDuration)
+		if !inMemoryRateLimiter.Request("API_KEY_RATE_LIMIT"+apiKey.Key, apiKey.RateLimit, 60) {
+			model.AbortWithOpenAIError(c, model.NewAPIError(http.StatusTooManyRequests, "rate_limit_exceeded",
+				"API-KEY请求过于频繁,请稍后再试").WithRetryAfter(60))
+			return
+		}
+	}
+
+	c.Set(helper.ApiKeyContextKey, apiKey)
+
 	//if config.ApiSecret == "" {
 	//	c.Request.Header.Set("Authorization", "")
 	//}
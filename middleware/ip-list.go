@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"alexsidebar2api/common/config"
+	"alexsidebar2api/model"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strings"
@@ -17,7 +18,7 @@ func IPBlacklistMiddleware() gin.HandlerFunc {
 		for _, blockedIP := range config.IpBlackList {
 			if strings.TrimSpace(blockedIP) == clientIP {
 				// 如果在黑名单中，返回403 Forbidden
-				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+				model.AbortWithOpenAIError(c, model.NewAPIError(http.StatusForbidden, "ip_blocked", "Forbidden"))
 				return
 			}
 		}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"alexsidebar2api/common"
 	"alexsidebar2api/common/config"
+	"alexsidebar2api/model"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
@@ -14,11 +15,8 @@ var inMemoryRateLimiter common.InMemoryRateLimiter
 func memoryRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
 	key := mark + c.ClientIP()
 	if !inMemoryRateLimiter.Request(key, maxRequestNum, duration) {
-		c.JSON(http.StatusTooManyRequests, gin.H{
-			"success": false,
-			"message": "请求过于频繁,请稍后再试",
-		})
-		c.Abort()
+		model.AbortWithOpenAIError(c, model.NewAPIError(http.StatusTooManyRequests, "rate_limit_exceeded",
+			"请求过于频繁,请稍后再试").WithRetryAfter(int(duration)))
 		return
 	}
 }
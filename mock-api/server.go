@@ -0,0 +1,225 @@
+package mock_api
+
+import (
+	"alexsidebar2api/common/config"
+	logger "alexsidebar2api/common/loggger"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const frameSeparator = "›"
+
+// 在最后一条消息中携带以下指令即可让模拟上游返回对应的错误
+var errorScenarios = map[string]struct {
+	status int
+	body   string
+}{
+	"[mock:usage_limit]": {http.StatusPaymentRequired, `{"error":"Usage limit exceeded","message":"You have reached your Kilo Code usage limit. Please upgrade your plan."}`},
+	"[mock:rate_limit]":  {http.StatusTooManyRequests, `{"error":"Too many concurrent requests","message":"You have reached your maximum concurrent request limit. Please try again later."}`},
+	"[mock:not_login]":   {http.StatusUnauthorized, `{"error":"Invalid token"}`},
+	"[mock:chinese]":     {http.StatusUnauthorized, `{"detail":"Bearer authentication is needed"}`},
+	"[mock:server_error]": {http.StatusServiceUnavailable,
+		`{"error":"Service Unavailable","message":"The service is temporarily unavailable. Please try again later."}`},
+	"[mock:cloudflare_block]": {http.StatusForbidden,
+		`<!DOCTYPE html><html lang="en-US"><head><title>Attention Required! | Cloudflare</title></head><body><h1 data-translate="block_headline">Sorry, you have been blocked</h1></body></html>`},
+	"[mock:cloudflare_challenge]": {http.StatusForbidden,
+		`<!DOCTYPE html><html lang="en-US"><head><title>Just a moment...</title><meta http-equiv="refresh" content="390"></head><body><script>window._cf_chl_opt={cType: 'managed'};</script></body></html>`},
+}
+
+// disconnectReply [mock:disconnect] 的回复, 首次输出一半后断开连接, 续写请求从中断处之前重复一小段后继续
+const disconnectReply = "The quick brown fox jumps over the lazy dog, then it keeps running across the wide green field until the sun goes down behind the hills."
+
+type section struct {
+	Text *textSection `json:"text,omitempty"`
+	Code *codeSection `json:"code,omitempty"`
+}
+
+type textSection struct {
+	Text       string `json:"text"`
+	IsThinking bool   `json:"is_thinking"`
+}
+
+type codeSection struct {
+	Code     string `json:"code"`
+	Language string `json:"language,omitempty"`
+	FilePath string `json:"file_path,omitempty"`
+}
+
+type chatRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role       string `json:"role"`
+		ThinkFirst bool   `json:"think_first"`
+		Sections   []struct {
+			Text *textSection `json:"text"`
+		} `json:"sections"`
+	} `json:"messages"`
+}
+
+// Start 启动模拟上游, 提供 token 刷新和对话两个接口
+// 端口监听成功后才返回, 之后的请求无需等待
+func Start(port int) error {
+	gin.SetMode(gin.ReleaseMode)
+	server := gin.New()
+	server.Use(gin.Recovery())
+	SetRouter(server)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to start mock upstream: %v", err)
+	}
+	logger.SysLog(fmt.Sprintf("mock upstream listening on :%d", port))
+	go func() {
+		if err := server.RunListener(listener); err != nil {
+			logger.FatalLog("mock upstream stopped: " + err.Error())
+		}
+	}()
+	return nil
+}
+
+// BaseURL 模拟上游的访问地址
+func BaseURL(port int) string {
+	return fmt.Sprintf("http://127.0.0.1:%d", port)
+}
+
+func SetRouter(router *gin.Engine) {
+	router.POST("/v1/token", refreshToken)
+	router.POST("/call_assistant5", callAssistant)
+}
+
+func refreshToken(c *gin.Context) {
+	refreshToken := c.PostForm("refresh_token")
+	if refreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "MISSING_REFRESH_TOKEN"}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  "mock-access-" + refreshToken,
+		"expires_in":    "3600",
+		"token_type":    "Bearer",
+		"refresh_token": refreshToken,
+		"id_token":      "mock-id-" + refreshToken,
+		"user_id":       "mock-user",
+		"project_id":    "mock-project",
+	})
+}
+
+func callAssistant(c *gin.Context) {
+	if c.GetHeader("auth") == "" {
+		c.String(http.StatusUnauthorized, `{"error":"Invalid token"}`)
+		return
+	}
+
+	var req chatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, `{"error":"Invalid request body"}`)
+		return
+	}
+
+	messageText := func(i int) string {
+		var text string
+		if i >= 0 && i < len(req.Messages) {
+			for _, s := range req.Messages[i].Sections {
+				if s.Text != nil {
+					text += s.Text.Text
+				}
+			}
+		}
+		return text
+	}
+
+	var prompt string
+	var thinkFirst bool
+	if n := len(req.Messages); n > 0 {
+		thinkFirst = req.Messages[n-1].ThinkFirst
+		prompt = messageText(n - 1)
+	}
+
+	// 续写请求: 助手消息为中断前的输出, 其后是续写指令
+	var partial string
+	if n := len(req.Messages); n >= 3 && req.Messages[n-2].Role == "Assistant" &&
+		strings.Contains(messageText(n-3), "[mock:disconnect]") {
+		partial = messageText(n - 2)
+	}
+
+	for directive, scenario := range errorScenarios {
+		if strings.Contains(prompt, directive) {
+			c.String(scenario.status, scenario.body)
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	var sections []section
+	emit := func() bool {
+		frame, err := json.Marshal(map[string]interface{}{"sections": sections})
+		if err != nil {
+			return false
+		}
+		if _, err := c.Writer.Write(append(frame, []byte(frameSeparator)...)); err != nil {
+			return false
+		}
+		c.Writer.Flush()
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-time.After(time.Duration(config.MockUpstreamDelay) * time.Millisecond):
+			return true
+		}
+	}
+
+	// 每一帧携带全部 section 的累计内容, 与真实上游一致
+	streamText := func(text string, thinking bool) bool {
+		sections = append(sections, section{Text: &textSection{IsThinking: thinking}})
+		current := sections[len(sections)-1].Text
+		for _, word := range strings.SplitAfter(text, " ") {
+			current.Text += word
+			if !emit() {
+				return false
+			}
+		}
+		return true
+	}
+
+	if partial != "" {
+		start := max(0, min(len(partial), len(disconnectReply))-20)
+		streamText(disconnectReply[start:], false)
+		return
+	}
+	if strings.Contains(prompt, "[mock:disconnect]") {
+		streamText(disconnectReply[:len(disconnectReply)/2], false)
+		if conn, _, err := c.Writer.Hijack(); err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	if thinkFirst && !streamText("Let me think about this request step by step.", true) {
+		return
+	}
+	if !streamText(fmt.Sprintf("Mock reply from %s to: %s", req.Model, prompt), false) {
+		return
+	}
+	if strings.Contains(prompt, "[mock:code]") {
+		sections = append(sections, section{Code: &codeSection{Language: "go", FilePath: "main.go"}})
+		current := sections[len(sections)-1].Code
+		for _, line := range strings.SplitAfter("package main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n", "\n") {
+			current.Code += line
+			if !emit() {
+				return
+			}
+		}
+		streamText("Done.", false)
+	}
+	if strings.Contains(prompt, "[mock:edit]") {
+		streamText("Updating main.go.\n<old_code path=\"main.go\">\nprintln(\"hello\")\n</old_code>\n<new_code path=\"main.go\">\nprintln(\"hi\")\n</new_code>\nDone.", false)
+	}
+}
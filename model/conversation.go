@@ -0,0 +1,222 @@
+package model
+
+import (
+	"alexsidebar2api/common/random"
+	"alexsidebar2api/common/store"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Conversation 服务端保存的会话, 请求携带 conversation_id 时只需发送最新的消息
+type Conversation struct {
+	ID        string              `json:"id"`
+	Object    string              `json:"object"`
+	Owner     string              `json:"-"`
+	Model     string              `json:"model,omitempty"`
+	Title     string              `json:"title,omitempty"`
+	ParentID  string              `json:"parent_id,omitempty"` // 分叉来源
+	Messages  []OpenAIChatMessage `json:"messages"`
+	CreatedAt int64               `json:"created_at"`
+	UpdatedAt int64               `json:"updated_at"`
+}
+
+// ConversationSummary 列表中的会话, 不含消息内容
+type ConversationSummary struct {
+	ID           string `json:"id"`
+	Object       string `json:"object"`
+	Model        string `json:"model,omitempty"`
+	Title        string `json:"title,omitempty"`
+	ParentID     string `json:"parent_id,omitempty"`
+	MessageCount int    `json:"message_count"`
+	CreatedAt    int64  `json:"created_at"`
+	UpdatedAt    int64  `json:"updated_at"`
+}
+
+type ConversationListResponse struct {
+	Object string                `json:"object"`
+	Data   []ConversationSummary `json:"data"`
+}
+
+type CreateConversationRequest struct {
+	Model    string              `json:"model"`
+	Title    string              `json:"title"`
+	Messages []OpenAIChatMessage `json:"messages"`
+}
+
+type ForkConversationRequest struct {
+	MessageCount int `json:"message_count"` // 保留的消息数, 0 表示全部
+}
+
+type ConversationDeletedResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// conversations 每个会话一个文件, 按归属分目录, 写入一轮对话不会重写其他会话
+// 概要单独保存, 列表时无需读取消息
+func conversations(owner string) *store.Collection {
+	return store.GetCollection("conversations/" + ownerDir(owner))
+}
+
+func conversationSummaries(owner string) *store.Collection {
+	return store.GetCollection("conversation_summaries/" + ownerDir(owner))
+}
+
+// ownerDir 未配置密钥时会话归属为空
+func ownerDir(owner string) string {
+	if owner == "" {
+		return "default"
+	}
+	return owner
+}
+
+// ConversationOwner 会话归属, 取密钥摘要避免明文落盘
+func ConversationOwner(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Summary 会话概要
+func (c Conversation) Summary() ConversationSummary {
+	return ConversationSummary{
+		ID:           c.ID,
+		Object:       c.Object,
+		Model:        c.Model,
+		Title:        c.Title,
+		ParentID:     c.ParentID,
+		MessageCount: len(c.Messages),
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
+	}
+}
+
+// NewConversation 创建并保存会话
+func NewConversation(owner, model, title string, messages []OpenAIChatMessage) (Conversation, error) {
+	conversation := newConversation(owner, model, title, messages)
+	return conversation, saveConversation(conversation)
+}
+
+func newConversation(owner, model, title string, messages []OpenAIChatMessage) Conversation {
+	now := time.Now().Unix()
+	conversation := Conversation{
+		ID:        "conv_" + random.GetRandomString(24),
+		Object:    "conversation",
+		Owner:     owner,
+		Model:     model,
+		Title:     title,
+		Messages:  append([]OpenAIChatMessage{}, messages...),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if conversation.Title == "" {
+		conversation.Title = conversationTitle(messages)
+	}
+	return conversation
+}
+
+// GetConversation 获取属于 owner 的会话
+func GetConversation(owner, id string) (Conversation, error) {
+	var conversation Conversation
+	ok, err := conversations(owner).Get(id, &conversation)
+	if err != nil {
+		return Conversation{}, err
+	}
+	if !ok {
+		return Conversation{}, ErrConversationNotFound
+	}
+	conversation.Owner = owner
+	return conversation, nil
+}
+
+// ListConversations 按更新时间倒序返回属于 owner 的会话
+func ListConversations(owner string) ([]ConversationSummary, error) {
+	result, err := store.ListCollection[ConversationSummary](conversationSummaries(owner))
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].UpdatedAt > result[j].UpdatedAt })
+	return result, nil
+}
+
+// AppendConversation 追加一轮对话, 同一会话的并发请求依次追加
+func AppendConversation(owner, id, model string, messages ...OpenAIChatMessage) error {
+	unlock := conversations(owner).Lock(id)
+	defer unlock()
+
+	conversation, err := GetConversation(owner, id)
+	if err != nil {
+		return err
+	}
+	conversation.Messages = append(conversation.Messages, messages...)
+	if conversation.Model == "" {
+		conversation.Model = model
+	}
+	if conversation.Title == "" {
+		conversation.Title = conversationTitle(conversation.Messages)
+	}
+	conversation.UpdatedAt = time.Now().Unix()
+	return saveConversation(conversation)
+}
+
+// ForkConversation 复制会话的前 count 条消息为新会话, count 小于等于 0 时复制全部
+func ForkConversation(owner, id string, count int) (Conversation, error) {
+	source, err := GetConversation(owner, id)
+	if err != nil {
+		return Conversation{}, err
+	}
+	messages := source.Messages
+	if count > 0 && count < len(messages) {
+		messages = messages[:count]
+	}
+	conversation := newConversation(owner, source.Model, source.Title, messages)
+	conversation.ParentID = source.ID
+	return conversation, saveConversation(conversation)
+}
+
+// DeleteConversation 删除属于 owner 的会话
+func DeleteConversation(owner, id string) error {
+	unlock := conversations(owner).Lock(id)
+	defer unlock()
+
+	ok, err := conversations(owner).Delete(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrConversationNotFound
+	}
+	_, err = conversationSummaries(owner).Delete(id)
+	return err
+}
+
+// saveConversation 先写会话再写概要, 概要缺失的会话不会出现在列表中
+func saveConversation(conversation Conversation) error {
+	if err := conversations(conversation.Owner).Put(conversation.ID, conversation); err != nil {
+		return err
+	}
+	return conversationSummaries(conversation.Owner).Put(conversation.ID, conversation.Summary())
+}
+
+// conversationTitle 以第一条用户消息作为标题
+func conversationTitle(messages []OpenAIChatMessage) string {
+	for _, message := range messages {
+		if message.Role != "user" {
+			continue
+		}
+		title := []rune(message.TextContent())
+		if len(title) > 50 {
+			title = append(title[:50], []rune("...")...)
+		}
+		return string(title)
+	}
+	return ""
+}
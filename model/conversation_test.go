@@ -0,0 +1,88 @@
+package model
+
+import (
+	"alexsidebar2api/common/store"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "model-test")
+	if err != nil {
+		panic(err)
+	}
+	store.DataPath = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestConversationConcurrentAppend(t *testing.T) {
+	owner := ConversationOwner("sk-test")
+	conversation, err := NewConversation(owner, "claude-3-7-sonnet", "", []OpenAIChatMessage{{Role: "user", Content: "hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const turns = 20
+	var wg sync.WaitGroup
+	for i := 0; i < turns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := AppendConversation(owner, conversation.ID, "",
+				OpenAIChatMessage{Role: "user", Content: "q"},
+				OpenAIChatMessage{Role: "assistant", Content: "a"})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := GetConversation(owner, conversation.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Messages) != 1+2*turns {
+		t.Errorf("expected %d messages, got %d", 1+2*turns, len(got.Messages))
+	}
+	list, err := ListConversations(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].MessageCount != 1+2*turns || list[0].Title != "hi" {
+		t.Errorf("unexpected summary %+v", list)
+	}
+}
+
+func TestConversationIsolation(t *testing.T) {
+	alice, bob := ConversationOwner("sk-alice"), ConversationOwner("sk-bob")
+	conversation, err := NewConversation(alice, "", "mine", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetConversation(bob, conversation.ID); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("other tenant read conversation: %v", err)
+	}
+	if err := DeleteConversation(bob, conversation.ID); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("other tenant deleted conversation: %v", err)
+	}
+	if list, _ := ListConversations(bob); len(list) != 0 {
+		t.Errorf("other tenant listed %+v", list)
+	}
+	// 会话 id 来自请求路径, 不能越出会话目录
+	if _, err := GetConversation(alice, "../"+filepath.Base(conversation.ID)); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("path traversal id resolved: %v", err)
+	}
+
+	if err := DeleteConversation(alice, conversation.ID); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := ListConversations(alice); len(list) != 0 {
+		t.Errorf("deleted conversation still listed: %+v", list)
+	}
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIError 对外返回的错误, 以 OpenAI 格式返回
+type APIError struct {
+	Status     int
+	Type       string
+	Code       string
+	Message    string
+	RetryAfter int // 秒, 大于0时设置 Retry-After 响应头
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// NewAPIError 按状态码推断错误类型
+func NewAPIError(status int, code, message string) *APIError {
+	return &APIError{
+		Status:  status,
+		Type:    openAIErrorType(status),
+		Code:    code,
+		Message: message,
+	}
+}
+
+// WithRetryAfter 设置建议的重试间隔(秒)
+func (e *APIError) WithRetryAfter(seconds int) *APIError {
+	e.RetryAfter = seconds
+	return e
+}
+
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status == http.StatusRequestTimeout:
+		return "timeout_error"
+	case status >= 500:
+		return "server_error"
+	}
+	return "invalid_request_error"
+}
+
+// OpenAI OpenAI 格式的错误
+func (e *APIError) OpenAI() OpenAIErrorResponse {
+	return OpenAIErrorResponse{
+		OpenAIError: OpenAIError{
+			Message: e.Message,
+			Type:    e.Type,
+			Code:    e.Code,
+		},
+	}
+}
+
+func (e *APIError) setHeaders(c *gin.Context) {
+	if e.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(e.RetryAfter))
+	}
+}
+
+// AbortWithOpenAIError 以 OpenAI 格式返回错误并终止后续处理
+func AbortWithOpenAIError(c *gin.Context, e *APIError) {
+	e.setHeaders(c)
+	c.AbortWithStatusJSON(e.Status, e.OpenAI())
+}
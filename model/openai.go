@@ -11,12 +11,63 @@ type OpenAIChatCompletionRequest struct {
 	Messages    []OpenAIChatMessage `json:"messages"`
 	MaxTokens   int                 `json:"max_tokens"`
 	Temperature float64             `json:"temperature"`
+	CodeBlocks  bool                `json:"code_blocks"` // 扩展: 额外返回结构化的代码块
+	Tools       []OpenAITool        `json:"tools,omitempty"`
+	// 扩展: 服务端会话, messages 只需包含最新的消息
+	ConversationID string `json:"conversation_id,omitempty"`
+	// 扩展: 不应用 PRE_MESSAGES_JSON 及提示词模板
+	SkipPromptTemplate bool `json:"skip_prompt_template,omitempty"`
+	// 提示词模板插入的预置消息数, 位于开头的 system 消息之后, 上下文管理时不裁剪
+	PinnedMessages int `json:"-"`
+}
+
+type OpenAITool struct {
+	Type     string             `json:"type"`
+	Function OpenAIToolFunction `json:"function"`
+}
+
+type OpenAIToolFunction struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+// HasTool 请求是否声明了指定名称的函数工具
+func (r *OpenAIChatCompletionRequest) HasTool(name string) bool {
+	for _, tool := range r.Tools {
+		if tool.Function.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
 type OpenAIChatMessage struct {
-	Role    string      `json:"role"`
-	Content interface{} `json:"content"`
-	Type    string
+	Role       string      `json:"role"`
+	Content    interface{} `json:"content"`
+	Type       string
+	Name       string           `json:"name,omitempty"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`   // assistant 消息发起的工具调用
+	ToolCallID string           `json:"tool_call_id,omitempty"` // tool 消息对应的调用
+}
+
+// TextContent 消息中的文本内容, 多段内容以换行拼接
+func (m OpenAIChatMessage) TextContent() string {
+	switch content := m.Content.(type) {
+	case string:
+		return content
+	case []interface{}:
+		var texts []string
+		for _, part := range content {
+			if partMap, ok := part.(map[string]interface{}); ok && partMap["type"] == "text" {
+				if text, ok := partMap["text"].(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+	return ""
 }
 
 // 修正后的Claude请求结构
@@ -54,13 +105,8 @@ func (r *OpenAIChatCompletionRequest) AddMessage(message OpenAIChatMessage) {
 	r.Messages = append([]OpenAIChatMessage{message}, r.Messages...)
 }
 
-func (r *OpenAIChatCompletionRequest) PrependMessagesFromJSON(jsonString string) error {
-	var newMessages []OpenAIChatMessage
-	err := json.Unmarshal([]byte(jsonString), &newMessages)
-	if err != nil {
-		return err
-	}
-
+// PrependMessages 将消息插入到最后一条 system 消息之后
+func (r *OpenAIChatCompletionRequest) PrependMessages(newMessages []OpenAIChatMessage) {
 	// 查找最后一个 system role 的索引
 	var insertIndex int
 	for i := len(r.Messages) - 1; i >= 0; i-- {
@@ -71,36 +117,9 @@ func (r *OpenAIChatCompletionRequest) PrependMessagesFromJSON(jsonString string)
 	}
 
 	// 将 newMessages 插入到找到的索引后面
-	r.Messages = append(r.Messages[:insertIndex], append(newMessages, r.Messages[insertIndex:]...)...)
-	return nil
-}
-
-func (r *OpenAIChatCompletionRequest) SystemMessagesProcess(model string) {
-	if r.Messages == nil {
-		return
-	}
-
-	for i := range r.Messages {
-		if r.Messages[i].Role == "system" {
-			r.Messages[i].Role = "user"
-		}
-
-	}
-
-}
-
-func (r *OpenAIChatCompletionRequest) FilterUserMessage() {
-	if r.Messages == nil {
-		return
-	}
-
-	// 返回最后一个role为user的元素
-	for i := len(r.Messages) - 1; i >= 0; i-- {
-		if r.Messages[i].Role == "user" {
-			r.Messages = r.Messages[i:]
-			break
-		}
-	}
+	messages := append([]OpenAIChatMessage{}, r.Messages[:insertIndex]...)
+	messages = append(messages, newMessages...)
+	r.Messages = append(messages, r.Messages[insertIndex:]...)
 }
 
 type OpenAIErrorResponse struct {
@@ -123,6 +142,18 @@ type OpenAIChatCompletionResponse struct {
 	Usage             OpenAIUsage    `json:"usage"`
 	SystemFingerprint *string        `json:"system_fingerprint"`
 	Suggestions       []string       `json:"suggestions"`
+	ConversationID    string         `json:"conversation_id,omitempty"`
+}
+
+// ChatPreviewResponse 应用模板、上下文管理及角色映射后的最终提示词
+type ChatPreviewResponse struct {
+	Object            string                 `json:"object"`
+	Model             string                 `json:"model"`
+	Templates         int                    `json:"templates"`                    // 生效的模板数
+	ContextManagement string                 `json:"context_management,omitempty"` // 上下文管理结果, 未处理时为空
+	Messages          []OpenAIChatMessage    `json:"messages"`
+	PromptTokens      int                    `json:"prompt_tokens"`
+	UpstreamRequest   map[string]interface{} `json:"upstream_request"`
 }
 
 type OpenAIChoice struct {
@@ -134,8 +165,29 @@ type OpenAIChoice struct {
 }
 
 type OpenAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	CodeBlocks []CodeBlock      `json:"code_blocks,omitempty"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type OpenAIToolCall struct {
+	Index    int                    `json:"index"`
+	ID       string                 `json:"id"`
+	Type     string                 `json:"type"`
+	Function OpenAIToolCallFunction `json:"function"`
+}
+
+type OpenAIToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// CodeBlock 上游代码段
+type CodeBlock struct {
+	Language string `json:"language"`
+	FilePath string `json:"file_path"`
+	Code     string `json:"code"`
 }
 
 type OpenAIUsage struct {
@@ -145,8 +197,10 @@ type OpenAIUsage struct {
 }
 
 type OpenAIDelta struct {
-	Content string `json:"content"`
-	Role    string `json:"role"`
+	Content    string           `json:"content"`
+	Role       string           `json:"role"`
+	CodeBlocks []CodeBlock      `json:"code_blocks,omitempty"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
 }
 
 type OpenAIImagesGenerationRequest struct {
@@ -280,6 +334,12 @@ func (r *OpenAIChatCompletionRequest) RemoveEmptyContentMessages() *OpenAIChatCo
 
 	var filteredMessages []OpenAIChatMessage
 	for _, msg := range r.Messages {
+		// 只有工具调用的 assistant 消息没有 content
+		if len(msg.ToolCalls) > 0 {
+			filteredMessages = append(filteredMessages, msg)
+			continue
+		}
+
 		// Check if content is nil
 		if msg.Content == nil {
 			continue
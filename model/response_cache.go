@@ -0,0 +1,285 @@
+package model
+
+import (
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/common/store"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CachedResponse 缓存的回复
+type CachedResponse struct {
+	Key          string `json:"key"`
+	Model        string `json:"model"`
+	Content      string `json:"content"`
+	FinishReason string `json:"finish_reason"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+// ResponseCacheStats 缓存统计
+type ResponseCacheStats struct {
+	Backend string `json:"backend"`
+	Entries int    `json:"entries"`
+	Bytes   int    `json:"bytes"`
+}
+
+const responseCacheBackendDisk = "disk"
+
+// responseCacheIndexEntry 索引中的条目, memory 后端同时保存回复
+type responseCacheIndexEntry struct {
+	createdAt int64
+	seq       uint64 // 写入顺序, 同一秒内写入的条目按此淘汰
+	size      int
+	cached    *CachedResponse
+}
+
+var (
+	// disk 后端每个条目单独保存为一个文件, 写入或淘汰只涉及对应的文件
+	responseCacheCollection = store.GetCollection("response_cache")
+	responseCacheMutex      sync.Mutex
+	responseCacheLoaded     bool
+	// 索引常驻内存, 用于过期判断及按写入时间淘汰; 文件读写不持有 responseCacheMutex
+	responseCacheIndex = map[string]responseCacheIndexEntry{}
+	responseCacheBytes int
+	responseCacheSeq   uint64
+)
+
+func responseCacheOnDisk() bool {
+	return config.ResponseCacheBackend == responseCacheBackendDisk
+}
+
+func expiredAt(createdAt int64) bool {
+	return config.ResponseCacheTTL > 0 && time.Now().Unix()-createdAt >= int64(config.ResponseCacheTTL)
+}
+
+// size 条目大小, 以回复内容长度计
+func (r CachedResponse) size() int {
+	return len(r.Content)
+}
+
+// loadResponseCache disk 后端首次访问时从文件重建索引, 调用方需持有锁
+func loadResponseCache() error {
+	if responseCacheLoaded {
+		return nil
+	}
+	responseCacheLoaded = true
+	if !responseCacheOnDisk() {
+		return nil
+	}
+	items, err := store.ListCollection[CachedResponse](responseCacheCollection)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].CreatedAt < items[j].CreatedAt })
+	for _, item := range items {
+		responseCacheSeq++
+		responseCacheIndex[item.Key] = responseCacheIndexEntry{createdAt: item.CreatedAt, seq: responseCacheSeq, size: item.size()}
+		responseCacheBytes += item.size()
+	}
+	return nil
+}
+
+// lookupResponseCacheIndex 加载并查询索引, 已过期的条目从索引中移除并返回待删除的文件
+func lookupResponseCacheIndex(key string) (responseCacheIndexEntry, bool, []string, error) {
+	responseCacheMutex.Lock()
+	defer responseCacheMutex.Unlock()
+
+	if err := loadResponseCache(); err != nil {
+		return responseCacheIndexEntry{}, false, nil, err
+	}
+	entry, ok := responseCacheIndex[key]
+	if !ok {
+		return entry, false, nil, nil
+	}
+	if expiredAt(entry.createdAt) {
+		unindexCachedResponse(key)
+		return entry, false, []string{key}, nil
+	}
+	return entry, true, nil, nil
+}
+
+// GetCachedResponse 获取未过期的缓存, 已过期的条目顺带删除
+func GetCachedResponse(key string) (CachedResponse, bool, error) {
+	entry, ok, removed, err := lookupResponseCacheIndex(key)
+	if err != nil || !ok {
+		if err == nil {
+			err = deleteCachedResponseFiles(removed)
+		}
+		return CachedResponse{}, false, err
+	}
+	if entry.cached != nil {
+		return *entry.cached, true, nil
+	}
+
+	var cached CachedResponse
+	found, err := responseCacheCollection.Get(key, &cached)
+	if err != nil || !found {
+		// 文件已被并发的淘汰删除, 同步移除索引
+		responseCacheMutex.Lock()
+		if current, ok := responseCacheIndex[key]; ok && current.createdAt == entry.createdAt {
+			unindexCachedResponse(key)
+		}
+		responseCacheMutex.Unlock()
+		return CachedResponse{}, false, err
+	}
+	return cached, true, nil
+}
+
+// PutCachedResponse 写入缓存, 超过条目数或总大小时淘汰最早的条目
+func PutCachedResponse(cached CachedResponse) error {
+	// 单条超过总大小上限时不缓存
+	if cached.size() > config.ResponseCacheMaxBytes {
+		return nil
+	}
+	cached.CreatedAt = time.Now().Unix()
+	entry := responseCacheIndexEntry{createdAt: cached.CreatedAt, size: cached.size()}
+
+	evicted, err := indexCachedResponse(cached, entry)
+	if err != nil {
+		return err
+	}
+	return deleteCachedResponseFiles(evicted)
+}
+
+// indexCachedResponse 写入文件及索引, 返回被淘汰的 key
+// 持有条目锁直到写入索引, 避免并发的淘汰删除刚写入的文件
+func indexCachedResponse(cached CachedResponse, entry responseCacheIndexEntry) ([]string, error) {
+	if responseCacheOnDisk() {
+		unlock := responseCacheCollection.Lock(cached.Key)
+		defer unlock()
+		if err := responseCacheCollection.Put(cached.Key, cached); err != nil {
+			return nil, err
+		}
+	} else {
+		entry.cached = &cached
+	}
+
+	responseCacheMutex.Lock()
+	defer responseCacheMutex.Unlock()
+	if err := loadResponseCache(); err != nil {
+		return nil, err
+	}
+	unindexCachedResponse(cached.Key)
+	responseCacheSeq++
+	entry.seq = responseCacheSeq
+	responseCacheIndex[cached.Key] = entry
+	responseCacheBytes += entry.size
+	return evictResponseCache(cached.Key), nil
+}
+
+// evictResponseCache 先移除过期条目, 仍超出上限时按写入时间淘汰, 返回被淘汰的 key, 调用方需持有锁
+// keep 为刚写入的条目, 不淘汰
+func evictResponseCache(keep string) []string {
+	if len(responseCacheIndex) <= config.ResponseCacheMaxEntries && responseCacheBytes <= config.ResponseCacheMaxBytes {
+		return nil
+	}
+	keys := make([]string, 0, len(responseCacheIndex))
+	for key := range responseCacheIndex {
+		if key != keep {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return responseCacheIndex[keys[i]].seq < responseCacheIndex[keys[j]].seq
+	})
+
+	var evicted []string
+	for _, key := range keys {
+		if expiredAt(responseCacheIndex[key].createdAt) {
+			unindexCachedResponse(key)
+			evicted = append(evicted, key)
+		}
+	}
+	for _, key := range keys {
+		if len(responseCacheIndex) <= config.ResponseCacheMaxEntries && responseCacheBytes <= config.ResponseCacheMaxBytes {
+			break
+		}
+		if _, ok := responseCacheIndex[key]; ok {
+			unindexCachedResponse(key)
+			evicted = append(evicted, key)
+		}
+	}
+	return evicted
+}
+
+// DeleteCachedResponse 删除缓存, 不存在时返回 false
+func DeleteCachedResponse(key string) (bool, error) {
+	responseCacheMutex.Lock()
+	if err := loadResponseCache(); err != nil {
+		responseCacheMutex.Unlock()
+		return false, err
+	}
+	_, ok := responseCacheIndex[key]
+	unindexCachedResponse(key)
+	responseCacheMutex.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	return true, deleteCachedResponseFiles([]string{key})
+}
+
+// PurgeResponseCache 清空缓存, 返回删除的数量
+func PurgeResponseCache() (int, error) {
+	responseCacheMutex.Lock()
+	defer responseCacheMutex.Unlock()
+
+	if err := loadResponseCache(); err != nil {
+		return 0, err
+	}
+	n := len(responseCacheIndex)
+	responseCacheIndex = map[string]responseCacheIndexEntry{}
+	responseCacheBytes = 0
+	if responseCacheOnDisk() {
+		if _, err := responseCacheCollection.Clear(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// GetResponseCacheStats 缓存统计
+func GetResponseCacheStats() (ResponseCacheStats, error) {
+	responseCacheMutex.Lock()
+	defer responseCacheMutex.Unlock()
+
+	if err := loadResponseCache(); err != nil {
+		return ResponseCacheStats{}, err
+	}
+	return ResponseCacheStats{
+		Backend: config.ResponseCacheBackend,
+		Entries: len(responseCacheIndex),
+		Bytes:   responseCacheBytes,
+	}, nil
+}
+
+// unindexCachedResponse 从索引中移除条目, 调用方需持有锁
+func unindexCachedResponse(key string) {
+	if entry, ok := responseCacheIndex[key]; ok {
+		delete(responseCacheIndex, key)
+		responseCacheBytes -= entry.size
+	}
+}
+
+// deleteCachedResponseFiles 删除已从索引中移除的条目的文件, 期间已重新写入的条目不删除
+func deleteCachedResponseFiles(keys []string) error {
+	if !responseCacheOnDisk() {
+		return nil
+	}
+	for _, key := range keys {
+		unlock := responseCacheCollection.Lock(key)
+		responseCacheMutex.Lock()
+		_, reindexed := responseCacheIndex[key]
+		responseCacheMutex.Unlock()
+		var err error
+		if !reindexed {
+			_, err = responseCacheCollection.Delete(key)
+		}
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"alexsidebar2api/common/config"
+	"alexsidebar2api/common/store"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// useDiskResponseCache 切换到空的 disk 后端, 测试结束后恢复配置
+func useDiskResponseCache(t *testing.T, maxEntries int) {
+	t.Helper()
+	backend, entries := config.ResponseCacheBackend, config.ResponseCacheMaxEntries
+	t.Cleanup(func() {
+		PurgeResponseCache()
+		config.ResponseCacheBackend, config.ResponseCacheMaxEntries = backend, entries
+		resetResponseCacheIndex()
+	})
+	config.ResponseCacheBackend = responseCacheBackendDisk
+	config.ResponseCacheMaxEntries = maxEntries
+	resetResponseCacheIndex()
+}
+
+// resetResponseCacheIndex 丢弃内存中的索引, 下次访问时从文件重建, 相当于重启
+func resetResponseCacheIndex() {
+	responseCacheMutex.Lock()
+	defer responseCacheMutex.Unlock()
+	responseCacheLoaded = false
+	responseCacheIndex = map[string]responseCacheIndexEntry{}
+	responseCacheBytes = 0
+}
+
+func cacheKey(i int) string {
+	return fmt.Sprintf("%064x", i)
+}
+
+func cacheFiles(t *testing.T) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(store.DataPath, "response_cache", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestResponseCacheDiskPerEntry disk 后端每个条目一个文件, 淘汰只删除对应的文件, 重启后恢复
+func TestResponseCacheDiskPerEntry(t *testing.T) {
+	useDiskResponseCache(t, 3)
+
+	for i := 0; i < 5; i++ {
+		if err := PutCachedResponse(CachedResponse{Key: cacheKey(i), Content: strings.Repeat("x", i+1), FinishReason: "stop"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := cacheFiles(t); len(files) != 3 {
+		t.Fatalf("expected 3 entry files after eviction, got %v", files)
+	}
+
+	resetResponseCacheIndex()
+	stats, err := GetResponseCacheStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 3 || stats.Bytes != 3+4+5 {
+		t.Errorf("unexpected stats after reload: %+v", stats)
+	}
+	cached, ok, err := GetCachedResponse(cacheKey(4))
+	if err != nil || !ok || cached.Content != "xxxxx" {
+		t.Errorf("expected newest entry to survive, got %+v %v %v", cached, ok, err)
+	}
+
+	if ok, err := DeleteCachedResponse(cacheKey(4)); err != nil || !ok {
+		t.Fatalf("delete: %v %v", ok, err)
+	}
+	if _, err := os.Stat(filepath.Join(store.DataPath, "response_cache", cacheKey(4)+".json")); !os.IsNotExist(err) {
+		t.Errorf("expected entry file to be removed, got %v", err)
+	}
+	if ok, _ := DeleteCachedResponse("../accounts"); ok {
+		t.Error("invalid key should not be deleted")
+	}
+}
+
+// TestResponseCacheDiskConcurrentPut 并发写入及淘汰后索引与文件一致
+func TestResponseCacheDiskConcurrentPut(t *testing.T) {
+	useDiskResponseCache(t, 8)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := PutCachedResponse(CachedResponse{Key: cacheKey(i % 20), Content: "answer", FinishReason: "stop"}); err != nil {
+				t.Error(err)
+			}
+			GetCachedResponse(cacheKey((i + 7) % 20))
+		}(i)
+	}
+	wg.Wait()
+
+	stats, err := GetResponseCacheStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries > 8 || stats.Entries != len(cacheFiles(t)) {
+		t.Errorf("index has %d entries, %d files on disk", stats.Entries, len(cacheFiles(t)))
+	}
+}
@@ -15,20 +15,25 @@ import (
 var tokenEncoderMap = map[string]*tiktoken.Tiktoken{}
 var defaultTokenEncoder *tiktoken.Tiktoken
 
+// InitTokenEncoders 加载 tiktoken 编码, 首次使用需下载编码文件(可通过 TIKTOKEN_CACHE_DIR 缓存)
+// 无法下载时退回按长度估算, 离线环境也能启动
 func InitTokenEncoders() {
 	logger.SysLog("initializing token encoders...")
 	gpt35TokenEncoder, err := tiktoken.EncodingForModel("gpt-3.5-turbo")
 	if err != nil {
-		logger.FatalLog(fmt.Sprintf("failed to get gpt-3.5-turbo token encoder: %s", err.Error()))
+		logger.SysError(fmt.Sprintf("failed to get gpt-3.5-turbo token encoder, falling back to estimated token counts: %s", err.Error()))
+		return
 	}
 	defaultTokenEncoder = gpt35TokenEncoder
 	gpt4oTokenEncoder, err := tiktoken.EncodingForModel("gpt-4o")
 	if err != nil {
-		logger.FatalLog(fmt.Sprintf("failed to get gpt-4o token encoder: %s", err.Error()))
+		logger.SysError(fmt.Sprintf("failed to get gpt-4o token encoder, using gpt-3.5-turbo encoder: %s", err.Error()))
+		gpt4oTokenEncoder = gpt35TokenEncoder
 	}
 	gpt4TokenEncoder, err := tiktoken.EncodingForModel("gpt-4")
 	if err != nil {
-		logger.FatalLog(fmt.Sprintf("failed to get gpt-4 token encoder: %s", err.Error()))
+		logger.SysError(fmt.Sprintf("failed to get gpt-4 token encoder, using gpt-3.5-turbo encoder: %s", err.Error()))
+		gpt4TokenEncoder = gpt35TokenEncoder
 	}
 	for _, model := range common.GetModelList() {
 		if strings.HasPrefix(model, "gpt-3.5") {
@@ -45,6 +50,9 @@ func InitTokenEncoders() {
 }
 
 func getTokenEncoder(model string) *tiktoken.Tiktoken {
+	if defaultTokenEncoder == nil {
+		return nil
+	}
 	tokenEncoder, ok := tokenEncoderMap[model]
 	if ok && tokenEncoder != nil {
 		return tokenEncoder
@@ -61,7 +69,11 @@ func getTokenEncoder(model string) *tiktoken.Tiktoken {
 	return defaultTokenEncoder
 }
 
+// getTokenNum 编码不可用时按每 4 字节一个 token 估算
 func getTokenNum(tokenEncoder *tiktoken.Tiktoken, text string) int {
+	if tokenEncoder == nil {
+		return (len(text) + 3) / 4
+	}
 	return len(tokenEncoder.Encode(text, nil, nil))
 }
 
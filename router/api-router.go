@@ -30,9 +30,30 @@ func SetApiRouter(router *gin.Engine) {
 	v1Router := router.Group(fmt.Sprintf("%s/v1", ProcessPath(config.RoutePrefix)))
 	v1Router.Use(middleware.OpenAIAuth())
 	v1Router.POST("/chat/completions", controller.ChatForOpenAI)
+	v1Router.POST("/chat/completions/preview", controller.PreviewChat)
 	//v1Router.POST("/images/generations", controller.ImagesForOpenAI)
 	v1Router.GET("/models", controller.OpenaiModels)
+	v1Router.GET("/conversations", controller.ListConversations)
+	v1Router.POST("/conversations", controller.CreateConversation)
+	v1Router.GET("/conversations/:id", controller.GetConversation)
+	v1Router.POST("/conversations/:id/fork", controller.ForkConversation)
+	v1Router.DELETE("/conversations/:id", controller.DeleteConversation)
 
+	// 管理接口需配置 BACKEND_SECRET
+	if config.BackendApiEnable == 1 && config.BackendSecret != "" {
+		apiRouter := router.Group(fmt.Sprintf("%s/api", ProcessPath(config.RoutePrefix)))
+		apiRouter.Use(middleware.BackendAuth())
+		apiRouter.GET("/keys", controller.ListApiKeys)
+		apiRouter.POST("/keys", controller.SaveApiKey)
+		apiRouter.DELETE("/keys/:key", controller.DeleteApiKey)
+		apiRouter.GET("/accounts", controller.ListAccounts)
+		apiRouter.PUT("/accounts/:id", controller.UpdateAccount)
+		apiRouter.POST("/accounts/:id/device/rotate", controller.RotateAccountDevice)
+		apiRouter.GET("/fingerprints", controller.ListFingerprints)
+		apiRouter.GET("/cache", controller.GetResponseCacheStats)
+		apiRouter.DELETE("/cache", controller.PurgeResponseCache)
+		apiRouter.DELETE("/cache/:key", controller.DeleteCachedResponse)
+	}
 }
 
 func ProcessPath(path string) string {